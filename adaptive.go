@@ -0,0 +1,67 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// adaptiveBusyEntries is the number of entries collected in a cycle from which the interval is shortened
+	adaptiveBusyEntries = 1000
+	// adaptiveBusyDurationRatio is the ratio of the log command duration to the interval from which the interval is shortened
+	adaptiveBusyDurationRatio = 0.1
+)
+
+// cycleStats is the output of the log commands run in a cycle, from which the adaptive interval is calculated.
+type cycleStats struct {
+	entries         int
+	commandDuration time.Duration
+}
+
+// adaptive returns true if the interval of the collector changes between MinInterval and MaxInterval.
+func (c *OSLogCollector) adaptive() bool {
+	return c.MaxInterval > 0
+}
+
+// adaptInterval halves the interval if the cycle collected many entries or the log command took long, and doubles it
+// if the cycle collected nothing, within MinInterval and MaxInterval.
+func (c *OSLogCollector) adaptInterval(stats cycleStats) {
+	if !c.adaptive() {
+		return
+	}
+
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	interval := c.currentInterval
+	busyDuration := time.Duration(float64(interval) * adaptiveBusyDurationRatio)
+
+	switch {
+	case stats.entries >= adaptiveBusyEntries || stats.commandDuration >= busyDuration:
+		c.currentInterval = max(interval/2, time.Duration(c.MinInterval)*time.Second)
+	case stats.entries == 0:
+		c.currentInterval = min(interval*2, time.Duration(c.MaxInterval)*time.Second)
+	}
+
+	if c.currentInterval != interval {
+		c.logger.Info("Interval changed", "interval", interval, "new_interval", c.currentInterval,
+			"entries", stats.entries, "command_duration", stats.commandDuration)
+	}
+}
+
+func validateAdaptiveInterval(c OSLogCollectorConfig) error {
+	if c.MinInterval == 0 && c.MaxInterval == 0 {
+		return nil
+	}
+
+	if c.MinInterval <= 0 || c.MaxInterval <= 0 {
+		return fmt.Errorf("min_interval and max_interval must be set together and greater than 0")
+	}
+	if c.Schedule != "" || c.Align {
+		return fmt.Errorf("min_interval and max_interval cannot be used with schedule or align")
+	}
+	if c.MinInterval > c.Interval || c.Interval > c.MaxInterval {
+		return fmt.Errorf("interval must be between min_interval and max_interval")
+	}
+	return nil
+}
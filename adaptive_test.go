@@ -0,0 +1,80 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// linesOutput returns the output of the number of lines followed by the summary line.
+func linesOutput(lines int) string {
+	return strings.Repeat("{}\n", lines) + fmt.Sprintf("{\"count\":%d,\"finished\":1}\n", lines)
+}
+
+func TestOSLogCollector_AdaptiveInterval(t *testing.T) {
+	nowTime := time.Date(2025, 1, 29, 0, 0, 0, 0, time.Local)
+	flextime.Fix(nowTime)
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	cfg := oslog_collector.OSLogCollectorConfig{
+		Name:         "test",
+		Predicate:    "process == 'test'",
+		OutputFile:   filepath.Join(workdir, "test.log"),
+		PositionFile: filepath.Join(workdir, "test.pos"),
+		Interval:     60,
+		MinInterval:  15,
+		MaxInterval:  240,
+	}
+
+	lines := 0
+	collector, err := oslog_collector.NewOSLogCollector(cfg, oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		return outputRunner(linesOutput(lines))
+	}))
+	require.NoError(t, err)
+	defer collector.Close()
+
+	assert.Equal(t, 60, collector.Status(3).CurrentInterval)
+
+	collect := func() int {
+		t.Helper()
+		flextime.Fix(flextime.Now().Add(time.Minute))
+		require.NoError(t, collector.CollectLogs(context.Background()))
+		return collector.Status(3).CurrentInterval
+	}
+
+	// the interval is doubled up to max_interval while nothing is collected
+	assert.Equal(t, []int{120, 240, 240}, []int{collect(), collect(), collect()})
+
+	// the interval is kept while a moderate number of logs are collected
+	lines = 10
+	assert.Equal(t, 240, collect())
+
+	// the interval is halved down to min_interval while many logs are collected
+	lines = 1000
+	assert.Equal(t, []int{120, 60, 30, 15, 15}, []int{collect(), collect(), collect(), collect(), collect()})
+
+	t.Run("when interval is not adaptive then current interval is omitted", func(t *testing.T) {
+		cfg := cfg
+		cfg.Name = "fixed"
+		cfg.PositionFile = filepath.Join(workdir, "fixed.pos")
+		cfg.MinInterval, cfg.MaxInterval = 0, 0
+
+		collector, err := oslog_collector.NewOSLogCollector(cfg, oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+			return outputRunner(linesOutput(0))
+		}))
+		require.NoError(t, err)
+		defer collector.Close()
+
+		require.NoError(t, collector.CollectLogs(context.Background()))
+		assert.Equal(t, 0, collector.Status(3).CurrentInterval)
+	})
+}
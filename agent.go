@@ -2,42 +2,138 @@ package oslog_collector
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"slices"
 	"sync"
 	"syscall"
+	"time"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 type Agent struct {
 	Config        *Config
 	LogCollectors []*OSLogCollector
-
+	// ArchiveWatchers ingest .logarchive bundles dropped into a directory
+	ArchiveWatchers []*ArchiveWatcher
+	// ConfigFilePath is the config file that is reloaded when the agent receives a SIGHUP signal
+	ConfigFilePath string
+	// Metrics records the health of the collectors and archive watchers, which is served by the HTTP listener
+	Metrics *Metrics
+
+	// ReopenLogCh, ReloadCh and ShutdownCh receive SIGUSR1, SIGHUP and SIGINT or SIGTERM while the agent is running
 	ReopenLogCh chan struct{}
+	ReloadCh    chan struct{}
 	ShutdownCh  chan struct{}
+
+	collectorOptions      []OSLogCollectorOption
+	archiveWatcherOptions []ArchiveWatcherOption
+	logFile               LogFile
+
+	pidLock *fileLock
+
+	mu    sync.Mutex
+	tasks map[string]*agentTask
+	// reloadMu serializes reloads, which release mu while waiting for the replaced tasks to stop
+	reloadMu sync.Mutex
+}
+
+// agentTask is a collector or archive watcher running in the background.
+type agentTask struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type AgentOption func(*Agent)
+
+// WithCollectorOptions sets the options of the collectors created by the agent, including the ones created on reload.
+func WithCollectorOptions(opts ...OSLogCollectorOption) AgentOption {
+	return func(a *Agent) {
+		a.collectorOptions = opts
+	}
+}
+
+// WithArchiveWatcherOptions sets the options of the archive watchers created by the agent, including the ones created on reload.
+func WithArchiveWatcherOptions(opts ...ArchiveWatcherOption) AgentOption {
+	return func(a *Agent) {
+		a.archiveWatcherOptions = opts
+	}
+}
+
+// WithLogFile sets the log file of the agent itself, which is reopened with the output files.
+func WithLogFile(logFile LogFile) AgentOption {
+	return func(a *Agent) {
+		a.logFile = logFile
+	}
+}
+
+// WithConfigFilePath sets the config file that is reloaded when the agent receives a SIGHUP signal.
+func WithConfigFilePath(configFilePath string) AgentOption {
+	return func(a *Agent) {
+		a.ConfigFilePath = configFilePath
+	}
 }
 
-func NewAgentFromConfigFile(configFilePath string) (*Agent, error) {
+func NewAgentFromConfigFile(configFilePath string, opts ...AgentOption) (*Agent, error) {
 	config, err := LoadConfigFromFile(configFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("error loading config: %w", err)
 	}
 
-	logCollectors, err := newOSLogCollectors(config)
+	return NewAgent(config, slices.Concat([]AgentOption{WithConfigFilePath(configFilePath)}, opts)...)
+}
+
+// NewAgent creates an agent from the loaded config.
+func NewAgent(config *Config, opts ...AgentOption) (*Agent, error) {
+	// fail before creating the collectors, which would fail to lock the position files used by the running agent
+	if err := checkAgentNotRunning(config.PIDFile); err != nil {
+		return nil, err
+	}
+
+	agent := &Agent{
+		Config:      config,
+		ReopenLogCh: make(chan struct{}),
+		ReloadCh:    make(chan struct{}),
+		ShutdownCh:  make(chan struct{}),
+		Metrics:     NewMetrics(),
+	}
+
+	for _, opt := range opts {
+		opt(agent)
+	}
+
+	limiter := NewCommandLimiter(config.MaxConcurrentCommands)
+	if limiter != nil {
+		limiter.metrics = agent.Metrics
+	}
+
+	agent.collectorOptions = slices.Concat(agent.collectorOptions, []OSLogCollectorOption{WithMetrics(agent.Metrics), WithCommandLimiter(limiter)})
+	agent.archiveWatcherOptions = slices.Concat(agent.archiveWatcherOptions, []ArchiveWatcherOption{WithArchiveWatcherMetrics(agent.Metrics), WithArchiveWatcherCommandLimiter(limiter)})
+
+	var err error
+	agent.LogCollectors, err = newOSLogCollectors(config, agent.collectorOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating log collectors: %w", err)
 	}
 
-	return &Agent{
-		Config:        config,
-		LogCollectors: logCollectors,
-		ReopenLogCh:   MakeReopenLogCh(),
-		ShutdownCh:    MakeShutdownCh(),
-	}, nil
+	agent.ArchiveWatchers, err = newArchiveWatchers(config, agent.archiveWatcherOptions...)
+	if err != nil {
+		// release the position files of the collectors, so that the caller can create them again
+		closeTasks("collector", agent.LogCollectors)
+		return nil, fmt.Errorf("error creating archive watchers: %w", err)
+	}
+
+	return agent, nil
 }
 
 func (a *Agent) Run() error {
+	stopSignals := a.relaySignals()
+	defer stopSignals()
+
 	if err := a.storePIDFile(a.Config.PIDFile); err != nil {
 		return err
 	}
@@ -48,64 +144,278 @@ func (a *Agent) Run() error {
 		}
 	}()
 
+	server, err := a.startHTTPServer()
+	if err != nil {
+		return err
+	}
+	defer stopHTTPServer(server)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+	control, err := a.startControlServer(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	for _, collector := range a.LogCollectors {
+		a.start(ctx, collector)
+	}
+	for _, watcher := range a.ArchiveWatchers {
+		a.start(ctx, watcher)
+	}
+	a.mu.Unlock()
+
+	slog.Info("oslog-collector agent started")
+
+loop:
+	for {
+		select {
+		case <-a.ReopenLogCh:
+			if err := a.reopenLogFiles(); err != nil {
+				slog.Error("Error reopening log files", "error", err)
+			}
+		case <-a.ReloadCh:
+			if err := a.Reload(ctx); err != nil {
+				slog.Error("Error reloading config, keeping the running config", "error", err)
+			}
+		case <-a.ShutdownCh:
+			break loop
+		}
+	}
+
+	// stop accepting control commands first, so that a reload does not start collectors while stopping
+	control.stop()
+	cancel()
+	a.stopAll()
+
+	slog.Info("oslog-collector agent stopped")
+
+	return nil
+}
+
+// Collectors returns the running collectors, which change when the config is reloaded.
+func (a *Agent) Collectors() []*OSLogCollector {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return slices.Clone(a.LogCollectors)
+}
+
+// Watchers returns the running archive watchers, which change when the config is reloaded.
+func (a *Agent) Watchers() []*ArchiveWatcher {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return slices.Clone(a.ArchiveWatchers)
+}
+
+// start runs the collector or the archive watcher in the background. The caller must hold a.mu.
+func (a *Agent) start(ctx context.Context, task reloadable) {
+	a.startTask(ctx, task.taskKey(), task.run)
+}
+
+// startTask runs the function in the background until the task is stopped. The caller must hold a.mu.
+func (a *Agent) startTask(ctx context.Context, key string, run func(ctx context.Context)) {
+	if a.tasks == nil {
+		a.tasks = map[string]*agentTask{}
+	}
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	task := &agentTask{cancel: cancel, done: make(chan struct{})}
+	a.tasks[key] = task
 
 	go func() {
-		defer wg.Done()
+		defer close(task.done)
+		run(taskCtx)
+	}()
+}
 
-		for {
+// cancelTasks cancels the running tasks of the keys and returns them. The caller must hold a.mu.
+func (a *Agent) cancelTasks(keys []string) map[string]*agentTask {
+	tasks := make(map[string]*agentTask, len(keys))
+	for _, key := range keys {
+		if task, ok := a.tasks[key]; ok {
+			task.cancel()
+			tasks[key] = task
+		}
+	}
+	return tasks
+}
+
+// waitTasks waits for the canceled tasks to finish until the shared timeout, and returns the keys of the stuck ones.
+// It does not need a.mu, so that the status and the control commands are served while waiting.
+func waitTasks(tasks map[string]*agentTask, timeout time.Duration) map[string]struct{} {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	stuck := map[string]struct{}{}
+	expired := false
+	for _, key := range sortedKeys(tasks) {
+		if expired {
 			select {
-			case <-a.ReopenLogCh:
-				if err := a.reopenLogFiles(); err != nil {
-					slog.Error("Error reopening log files", "error", err)
-				}
-			case <-a.ShutdownCh:
-				cancel()
-			case <-ctx.Done():
-				return
+			case <-tasks[key].done:
+			default:
+				stuck[key] = struct{}{}
 			}
+			continue
 		}
-	}()
 
-	slog.Info("oslog-collector agent started")
+		select {
+		case <-tasks[key].done:
+		case <-timer.C:
+			expired = true
+			stuck[key] = struct{}{}
+		}
+	}
+	return stuck
+}
 
-	go StartLogCollectors(ctx, a.LogCollectors)
+// forgetTasks removes the finished tasks, which are the canceled ones except the stuck ones. A stuck task is waited for
+// again by the next reload or stopAll. The caller must hold a.mu.
+func (a *Agent) forgetTasks(tasks map[string]*agentTask, stuck map[string]struct{}) {
+	for key, task := range tasks {
+		if _, ok := stuck[key]; !ok && a.tasks[key] == task {
+			delete(a.tasks, key)
+		}
+	}
+}
 
-	wg.Wait()
+// taskRunning reports whether the task has been started and has not finished. The caller must hold a.mu.
+func (a *Agent) taskRunning(key string) bool {
+	task, ok := a.tasks[key]
+	if !ok {
+		return false
+	}
 
-	slog.Info("oslog-collector agent stopped")
+	select {
+	case <-task.done:
+		return false
+	default:
+		return true
+	}
+}
 
-	return nil
+// stopAll stops the collectors and archive watchers and closes them. The running log commands are terminated,
+// and if a collector does not stop within the shutdown timeout, it is left running so that the agent can exit.
+// The tasks are waited for without holding a.mu, so that the status is served while stopping.
+func (a *Agent) stopAll() {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	a.mu.Lock()
+	tasks := a.cancelTasks(sortedKeys(a.tasks))
+	a.mu.Unlock()
+
+	stuck := waitTasks(tasks, a.shutdownTimeout())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.forgetTasks(tasks, stuck)
+	if len(stuck) > 0 {
+		slog.Error("Shutdown timed out, exiting without waiting for the stuck collectors", "stuck", sortedKeys(stuck))
+	}
+
+	// a stuck collector may still write to its output file, which is left open until the agent exits
+	closeTasks("collector", withoutStuck(a.LogCollectors, stuck))
+	closeTasks("archive watcher", withoutStuck(a.ArchiveWatchers, stuck))
+}
+
+// withoutStuck returns the collectors or the archive watchers except the stuck ones.
+func withoutStuck[T reloadable](tasks []T, stuck map[string]struct{}) []T {
+	return slices.DeleteFunc(slices.Clone(tasks), func(task T) bool {
+		_, ok := stuck[task.taskKey()]
+		return ok
+	})
+}
+
+func (a *Agent) shutdownTimeout() time.Duration {
+	if a.Config == nil || a.Config.ShutdownTimeout == 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(a.Config.ShutdownTimeout) * time.Second
+}
+
+func collectorTaskKey(name string) string {
+	return "collector/" + name
+}
+
+func archiveWatcherTaskKey(name string) string {
+	return "archive_watcher/" + name
 }
 
 func (a *Agent) reopenLogFiles() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.logFile != nil {
+		if err := a.logFile.Reopen(); err != nil {
+			return err
+		}
+	}
+
 	for _, collector := range a.LogCollectors {
 		if err := collector.OpenLogFile(); err != nil {
 			return err
 		}
 	}
+	for _, watcher := range a.ArchiveWatchers {
+		if err := watcher.OpenLogFile(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// storePIDFile writes the PID to the PID file and keeps it locked until removePIDFile,
+// so that another agent running the same config fails to start.
+// A PID file that is not locked was left by an agent that exited without removing it, and is overwritten.
 func (a *Agent) storePIDFile(pidPath string) error {
 	if pidPath == "" {
 		return nil
 	}
 
-	pidFile, err := os.OpenFile(pidPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
-	if err != nil {
-		return fmt.Errorf("failed to open pid file: %w", err)
+	lock, err := lockFile(pidPath, 0o600)
+	if errors.Is(err, errFileLocked) {
+		return fmt.Errorf("oslog-collector agent is already running (pid %d)", readPIDFile(pidPath))
+	} else if err != nil {
+		return fmt.Errorf("failed to lock pid file: %w", err)
+	}
+
+	if stalePID := readPIDFile(pidPath); stalePID != 0 {
+		slog.Warn("Overwriting stale PID file", "pid_file", pidPath, "pid", stalePID, "process_exists", processExists(stalePID))
 	}
-	defer pidFile.Close()
 
 	pid := os.Getpid()
-	if _, err = pidFile.WriteString(fmt.Sprintf("%d", pid)); err != nil {
+	if err := lock.file.Truncate(0); err != nil {
+		lock.Unlock()
 		return fmt.Errorf("failed to write pid file: %w", err)
 	}
+	if _, err := lock.file.WriteAt([]byte(fmt.Sprintf("%d", pid)), 0); err != nil {
+		lock.Unlock()
+		return fmt.Errorf("failed to write pid file: %w", err)
+	}
+
+	a.pidLock = lock
+	return nil
+}
+
+// checkAgentNotRunning returns an error if an agent holds the lock of the PID file.
+func checkAgentNotRunning(pidFile string) error {
+	if pidFile == "" {
+		return nil
+	}
+
+	locked, err := isFileLocked(pidFile)
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		return fmt.Errorf("oslog-collector agent is already running (pid %d)", readPIDFile(pidFile))
+	}
 
 	return nil
 }
@@ -114,14 +424,22 @@ func (a *Agent) removePIDFile(pidPath string) error {
 	if pidPath == "" {
 		return nil
 	}
-	return os.Remove(pidPath)
+
+	// remove the file before releasing the lock, so that a starting agent does not lock the removed file
+	err := os.Remove(pidPath)
+	if unlockErr := a.pidLock.Unlock(); err == nil {
+		err = unlockErr
+	}
+	a.pidLock = nil
+	return err
 }
 
-func newOSLogCollectors(config *Config) ([]*OSLogCollector, error) {
+func newOSLogCollectors(config *Config, opts ...OSLogCollectorOption) ([]*OSLogCollector, error) {
 	collectors := make([]*OSLogCollector, 0, len(config.Collectors))
 	for i := range config.Collectors {
-		collector, err := NewOSLogCollector(config.Collectors[i], WithLogCommandRunner(NewLogCommandRunner))
+		collector, err := NewOSLogCollector(config.Collectors[i], opts...)
 		if err != nil {
+			closeTasks("collector", collectors)
 			return nil, err
 		}
 		collectors = append(collectors, collector)
@@ -129,36 +447,78 @@ func newOSLogCollectors(config *Config) ([]*OSLogCollector, error) {
 	return collectors, nil
 }
 
-// MakeShutdownCh creates a channel that will be closed when the process receives a SIGINT or SIGTERM signal.
-func MakeShutdownCh() chan struct{} {
-	resultCh := make(chan struct{})
-
-	shutdownCh := make(chan os.Signal, 1)
-	signal.Notify(shutdownCh, os.Interrupt, syscall.SIGTERM)
-
-	go func() {
-		<-shutdownCh
-		close(resultCh)
-	}()
+func newArchiveWatchers(config *Config, opts ...ArchiveWatcherOption) ([]*ArchiveWatcher, error) {
+	watchers := make([]*ArchiveWatcher, 0, len(config.ArchiveWatchers))
+	for i := range config.ArchiveWatchers {
+		watcher, err := NewArchiveWatcher(config.ArchiveWatchers[i], opts...)
+		if err != nil {
+			closeTasks("archive watcher", watchers)
+			return nil, err
+		}
+		watchers = append(watchers, watcher)
+	}
+	return watchers, nil
+}
 
-	return resultCh
+// MakeShutdownCh creates a channel that receives a value when the process receives a SIGINT or SIGTERM signal.
+//
+// Deprecated: Run relays the signals to the channels of the agent while it runs, and NewAgent creates the channels.
+func MakeShutdownCh() chan struct{} {
+	ch := make(chan struct{})
+	relaySignals(map[os.Signal]chan struct{}{os.Interrupt: ch, syscall.SIGTERM: ch})
+	return ch
 }
 
-// MakeReopenLogCh creates a channel that will receive a value when the process receives a SIGUSR1 signal.
-// Some log rotate tools like newsyslog do not support copytruncate, so the old log file remains open.
-// When receiving the USR1 signal (30), reopen log collector's log file to handle this.
+// MakeReopenLogCh creates a channel that receives a value when the process receives a SIGUSR1 signal.
+//
+// Deprecated: Run relays the signals to the channels of the agent while it runs, and NewAgent creates the channels.
 func MakeReopenLogCh() chan struct{} {
-	resultCh := make(chan struct{})
+	ch := make(chan struct{})
+	relaySignals(map[os.Signal]chan struct{}{syscall.SIGUSR1: ch})
+	return ch
+}
 
-	reopenLogCh := make(chan os.Signal, 1)
-	signal.Notify(reopenLogCh, syscall.SIGUSR1)
+// relaySignals relays SIGINT and SIGTERM to ShutdownCh, SIGHUP to ReloadCh and SIGUSR1 to ReopenLogCh
+// until the returned function is called, which stops receiving the signals.
+// SIGUSR1 is sent by log rotate tools like newsyslog that do not support copytruncate, so that the output files are reopened.
+func (a *Agent) relaySignals() func() {
+	return relaySignals(map[os.Signal]chan struct{}{
+		os.Interrupt:    a.ShutdownCh,
+		syscall.SIGTERM: a.ShutdownCh,
+		syscall.SIGHUP:  a.ReloadCh,
+		syscall.SIGUSR1: a.ReopenLogCh,
+	})
+}
 
+// relaySignals sends a value to the channel of each signal received until the returned function is called,
+// which stops receiving the signals.
+func relaySignals(channels map[os.Signal]chan struct{}) func() {
+	signals := make(chan os.Signal, 1)
+	for sig := range channels {
+		signal.Notify(signals, sig)
+	}
+
+	done := make(chan struct{})
 	go func() {
 		for {
-			<-reopenLogCh
-			resultCh <- struct{}{}
+			var ch chan struct{}
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				ch = channels[sig]
+			}
+
+			select {
+			case ch <- struct{}{}:
+			case <-done:
+				return
+			}
 		}
 	}()
 
-	return resultCh
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
 }
@@ -1,9 +1,12 @@
 package oslog_collector_test
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -20,7 +23,6 @@ func TestAgent_Run(t *testing.T) {
 		agent := newDummyAgent(t, filename)
 
 		go func() {
-			time.Sleep(3 * time.Second)
 			agent.ShutdownCh <- struct{}{}
 		}()
 
@@ -35,30 +37,319 @@ func TestAgent_Run(t *testing.T) {
 		agent := newDummyAgent(t, filename)
 
 		go func() {
-			time.Sleep(3 * time.Second)
 			os.Remove(filename + ".log")
 
+			// the agent handles the signals in order, so the log is reopened before the shutdown
 			agent.ReopenLogCh <- struct{}{}
+			agent.ShutdownCh <- struct{}{}
+		}()
+
+		err := agent.Run()
+		require.NoError(t, err)
+
+		_, err = os.Stat(filename + ".log")
+		assert.NoError(t, err)
+	})
+
+	t.Run("when another agent is running the same config then fail to start", func(t *testing.T) {
+		workdir := t.TempDir()
+		filename := filepath.Join(workdir, fmt.Sprintf("%d", time.Now().UnixNano()))
+
+		agent := newDummyAgent(t, filename)
+
+		done := make(chan error)
+		go func() {
+			done <- agent.Run()
+		}()
+		require.Eventually(t, func() bool {
+			_, err := os.Stat(filename + ".pid")
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
 
-			time.Sleep(3 * time.Second)
+		another := &oslog_collector.Agent{Config: agent.Config}
+		err := another.Run()
+		assert.EqualError(t, err, fmt.Sprintf("oslog-collector agent is already running (pid %d)", os.Getpid()))
 
-			_, err := os.Stat(filename + ".log")
+		_, err = oslog_collector.NewOSLogCollector(agent.Config.Collectors[0])
+		assert.ErrorContains(t, err, "is locked by another oslog-collector process")
 
-			assert.NoError(t, err)
+		agent.ShutdownCh <- struct{}{}
+		require.NoError(t, <-done)
 
+		_, err = os.Stat(filename + ".pid")
+		assert.True(t, os.IsNotExist(err), "PID file is removed on shutdown")
+	})
+
+	t.Run("when PID file is stale then overwrite it", func(t *testing.T) {
+		workdir := t.TempDir()
+		filename := filepath.Join(workdir, fmt.Sprintf("%d", time.Now().UnixNano()))
+		require.NoError(t, os.WriteFile(filename+".pid", []byte("2147483647"), 0600))
+
+		agent := newDummyAgent(t, filename)
+
+		go func() {
+			assert.Eventually(t, func() bool {
+				data, err := os.ReadFile(filename + ".pid")
+				return err == nil && string(data) == fmt.Sprintf("%d", os.Getpid())
+			}, 5*time.Second, 10*time.Millisecond)
 			agent.ShutdownCh <- struct{}{}
 		}()
 
-		err := agent.Run()
+		require.NoError(t, agent.Run())
+	})
+}
+
+// stuckRunner returns a runner that reports when it starts and when its context is done, and then never returns
+// like a command that does not exit.
+func stuckRunner(started, canceled chan<- struct{}) runnerFunc {
+	return func(ctx context.Context) ([]byte, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		canceled <- struct{}{}
+		select {}
+	}
+}
+
+func TestAgent_Run_ShutdownTimeout(t *testing.T) {
+	workdir := t.TempDir()
+
+	agent := newDummyAgent(t, filepath.Join(workdir, "test"))
+	agent.Config.ShutdownTimeout = 1
+	agent.LogCollectors[0].Close()
+
+	writePastPosition(t, agent.Config.Collectors[0].PositionFile)
+	stuck, canceled := make(chan struct{}, 1), make(chan struct{}, 1)
+	collector, err := oslog_collector.NewOSLogCollector(agent.Config.Collectors[0], oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		return stuckRunner(stuck, canceled)
+	}))
+	require.NoError(t, err)
+	agent.LogCollectors[0] = collector
+
+	done := make(chan error)
+	go func() {
+		done <- agent.Run()
+	}()
+
+	<-stuck
+	started := time.Now()
+	agent.ShutdownCh <- struct{}{}
+
+	// the status is served while the agent waits for the stuck collector
+	<-canceled
+	status := make(chan oslog_collector.AgentStatus)
+	go func() {
+		status <- agent.Status()
+	}()
+	select {
+	case s := <-status:
+		assert.Len(t, s.Collectors, 1)
+	case <-time.After(500 * time.Millisecond):
+		t.Error("the status is locked while the agent waits for the stuck collector")
+	}
+
+	require.NoError(t, <-done)
+	assert.Less(t, time.Since(started), 3*time.Second, "the agent exits after shutdown_timeout even if a collector is stuck")
+
+	_, err = oslog_collector.NewOSLogCollector(agent.Config.Collectors[0])
+	assert.ErrorContains(t, err, "is locked by another oslog-collector process", "the stuck collector is not closed")
+}
+
+func TestAgent_Run_Signals(t *testing.T) {
+	workdir := t.TempDir()
+	configFile := filepath.Join(workdir, "oslog-collector.conf")
+	pidFile := filepath.Join(workdir, "oslog-collector.pid")
+
+	writeConfig := func(names ...string) {
+		config := "pid_file: " + pidFile + "\ncollectors:"
+		for _, name := range names {
+			config += fmt.Sprintf(`
+  - name: %[1]s
+    predicate: "process == '%[1]s'"
+    output_file: %[2]s/%[1]s.log
+    position_file: %[2]s/%[1]s.pos
+    interval: 60`, name, workdir)
+		}
+		require.NoError(t, os.WriteFile(configFile, []byte(config), 0644))
+	}
+	writeConfig("foo", "bar")
+
+	agent, err := oslog_collector.NewAgentFromConfigFile(configFile, oslog_collector.WithCollectorOptions(oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		return testLogRunner
+	})))
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- agent.Run()
+	}()
+
+	// the signals are relayed after the PID file is written
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(pidFile)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	writeConfig("bar")
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Eventually(t, func() bool {
+		collectors := agent.Collectors()
+		return len(collectors) == 1 && collectors[0].Name == "bar"
+	}, time.Second, 10*time.Millisecond, "SIGHUP reloads the config")
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case err := <-done:
 		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM does not stop the agent")
+	}
+}
+
+func TestMakeReopenLogCh(t *testing.T) {
+	reopenLogCh := oslog_collector.MakeReopenLogCh()
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	select {
+	case <-reopenLogCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGUSR1 is not relayed to the channel")
+	}
+}
+
+func TestNewAgent_ArchiveWatcherError(t *testing.T) {
+	workdir := t.TempDir()
+
+	config, err := oslog_collector.ParseConfig([]byte(fmt.Sprintf(`
+collectors:
+  - name: foo
+    predicate: "process == 'foo'"
+    output_file: %[1]s/foo.log
+    position_file: %[1]s/foo.pos
+    interval: 60
+archive_watchers:
+  - name: bar
+    directory: %[1]s/drop
+    predicate: "process == 'bar'"
+    output_file: %[1]s/missing/bar.log
+    state_file: %[1]s/bar.state
+    interval: 10
+    stable_seconds: 30
+`, workdir)))
+	require.NoError(t, err)
+
+	_, err = oslog_collector.NewAgent(config)
+	assert.ErrorContains(t, err, "error creating archive watchers")
+
+	collector, err := oslog_collector.NewOSLogCollector(config.Collectors[0])
+	require.NoError(t, err, "the collectors created before the error are closed")
+	require.NoError(t, collector.Close())
+}
+
+func TestAgent_Reload(t *testing.T) {
+	workdir := t.TempDir()
+	configFile := filepath.Join(workdir, "oslog-collector.conf")
+
+	collectorConfig := func(name string, interval int) string {
+		return fmt.Sprintf(`
+  - name: %[1]s
+    predicate: "process == '%[1]s'"
+    output_file: %[2]s/%[1]s.log
+    position_file: %[2]s/%[1]s.pos
+    interval: %[3]d`, name, workdir, interval)
+	}
+
+	writeConfig := func(collectors ...string) {
+		config := "pid_file: " + filepath.Join(workdir, "oslog-collector.pid") + "\ncollectors:"
+		for _, c := range collectors {
+			config += c
+		}
+		require.NoError(t, os.WriteFile(configFile, []byte(config), 0644))
+	}
+
+	collectorNames := func(collectors []*oslog_collector.OSLogCollector) []string {
+		names := make([]string, 0, len(collectors))
+		for _, c := range collectors {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	writeConfig(collectorConfig("foo", 1), collectorConfig("bar", 1), collectorConfig("baz", 1))
+
+	dummyRunnerGeenerator := func(args []string) oslog_collector.LogCommandRunner {
+		return testLogRunner
+	}
+
+	agent, err := oslog_collector.NewAgentFromConfigFile(configFile, oslog_collector.WithCollectorOptions(oslog_collector.WithLogCommandRunner(dummyRunnerGeenerator)))
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- agent.Run()
+	}()
+
+	before := agent.Collectors()
+	require.Equal(t, []string{"foo", "bar", "baz"}, collectorNames(before))
+
+	t.Run("when config is valid then apply the difference", func(t *testing.T) {
+		// remove foo, change the interval of bar, keep baz and add qux
+		writeConfig(collectorConfig("bar", 2), collectorConfig("baz", 1), collectorConfig("qux", 1))
+		agent.ReloadCh <- struct{}{}
+
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual([]string{"bar", "baz", "qux"}, collectorNames(agent.Collectors()))
+		}, 5*time.Second, 100*time.Millisecond)
+
+		after := agent.Collectors()
+		assert.NotSame(t, before[1], after[0], "changed collector is restarted")
+		assert.Equal(t, 2, after[0].Interval)
+		assert.Same(t, before[2], after[1], "unchanged collector keeps running")
+	})
+
+	t.Run("when config is invalid then keep the running config", func(t *testing.T) {
+		running := agent.Collectors()
+
+		writeConfig(collectorConfig("bar", 0))
+		agent.ReloadCh <- struct{}{}
+		// the next signal is received after the previous reload is finished
+		agent.ReopenLogCh <- struct{}{}
+
+		assert.Equal(t, running, agent.Collectors())
+	})
+
+	t.Run("when changed collector cannot be created then keep the running one", func(t *testing.T) {
+		writeConfig(collectorConfig("bar", 2), collectorConfig("baz", 1), collectorConfig("qux", 1))
+		agent.ReloadCh <- struct{}{}
+		agent.ReopenLogCh <- struct{}{}
+		running := agent.Collectors()
+
+		// the output file of baz cannot be opened
+		broken := strings.Replace(collectorConfig("baz", 2), workdir+"/baz.log", workdir+"/missing/baz.log", 1)
+		writeConfig(collectorConfig("bar", 2), broken, collectorConfig("qux", 1))
+		agent.ReloadCh <- struct{}{}
+		agent.ReopenLogCh <- struct{}{}
+
+		assert.Equal(t, running, agent.Collectors())
+		assert.Equal(t, 1, agent.Collectors()[1].Interval)
+
+		// the kept collector still holds the position file, and is replaced once the config is fixed
+		writeConfig(collectorConfig("bar", 2), collectorConfig("baz", 2), collectorConfig("qux", 1))
+		agent.ReloadCh <- struct{}{}
+		agent.ReopenLogCh <- struct{}{}
+
+		assert.NotSame(t, running[1], agent.Collectors()[1])
+		assert.Equal(t, 2, agent.Collectors()[1].Interval)
 	})
+
+	agent.ShutdownCh <- struct{}{}
+	require.NoError(t, <-done)
 }
 
 func newDummyAgent(t *testing.T, baseFilename string) *oslog_collector.Agent {
 	t.Helper()
 
 	dummyRunnerGeenerator := func(args []string) oslog_collector.LogCommandRunner {
-		return &mockLogCommandRunner{}
+		return testLogRunner
 	}
 
 	collectorCfg := oslog_collector.OSLogCollectorConfig{
@@ -84,9 +375,130 @@ func newDummyAgent(t *testing.T, baseFilename string) *oslog_collector.Agent {
 		LogCollectors: []*oslog_collector.OSLogCollector{
 			collector,
 		},
-		ReopenLogCh: oslog_collector.MakeReopenLogCh(),
-		ShutdownCh:  oslog_collector.MakeShutdownCh(),
+		ReopenLogCh: make(chan struct{}),
+		ShutdownCh:  make(chan struct{}),
 	}
 
 	return agent
 }
+
+func TestAgent_Reload_StuckCollector(t *testing.T) {
+	workdir := t.TempDir()
+	configFile := filepath.Join(workdir, "oslog-collector.conf")
+
+	writeConfig := func(names ...string) {
+		config := "pid_file: " + filepath.Join(workdir, "oslog-collector.pid") + "\nshutdown_timeout: 1\ncollectors:"
+		for _, name := range names {
+			config += fmt.Sprintf(`
+  - name: %[1]s
+    predicate: "process == '%[1]s'"
+    output_file: %[2]s/%[1]s.log
+    position_file: %[2]s/%[1]s.pos
+    interval: 1`, name, workdir)
+		}
+		require.NoError(t, os.WriteFile(configFile, []byte(config), 0644))
+	}
+	writeConfig("foo", "bar")
+	writePastPosition(t, filepath.Join(workdir, "foo.pos"))
+
+	stuck, canceled := make(chan struct{}, 1), make(chan struct{}, 1)
+	agent, err := oslog_collector.NewAgentFromConfigFile(configFile, oslog_collector.WithCollectorOptions(oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		if strings.Contains(strings.Join(args, " "), "foo") {
+			return stuckRunner(stuck, canceled)
+		}
+		return testLogRunner
+	})))
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- agent.Run()
+	}()
+
+	// wait for foo to get stuck in the first collection
+	<-stuck
+
+	started := time.Now()
+	writeConfig("bar")
+	agent.ReloadCh <- struct{}{}
+	agent.ReopenLogCh <- struct{}{}
+
+	assert.Less(t, time.Since(started), 3*time.Second, "the reload gives up waiting for the stuck collector after shutdown_timeout")
+	names := []string{}
+	for _, c := range agent.Collectors() {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"foo", "bar"}, names, "the stuck collector is kept until the next reload")
+
+	agent.ShutdownCh <- struct{}{}
+	require.NoError(t, <-done)
+}
+
+func TestAgent_Reload_StuckCollectors_SharedDeadline(t *testing.T) {
+	workdir := t.TempDir()
+	configFile := filepath.Join(workdir, "oslog-collector.conf")
+
+	writeConfig := func(names ...string) {
+		config := "pid_file: " + filepath.Join(workdir, "oslog-collector.pid") + "\nshutdown_timeout: 1\ncollectors:"
+		for _, name := range names {
+			config += fmt.Sprintf(`
+  - name: %[1]s
+    predicate: "process == '%[1]s'"
+    output_file: %[2]s/%[1]s.log
+    position_file: %[2]s/%[1]s.pos
+    interval: 1`, name, workdir)
+		}
+		require.NoError(t, os.WriteFile(configFile, []byte(config), 0644))
+	}
+	writeConfig("stuck1", "stuck2", "stuck3", "bar")
+	for _, name := range []string{"stuck1", "stuck2", "stuck3"} {
+		writePastPosition(t, filepath.Join(workdir, name+".pos"))
+	}
+
+	stuck, canceled := make(chan struct{}, 3), make(chan struct{}, 3)
+	agent, err := oslog_collector.NewAgentFromConfigFile(configFile, oslog_collector.WithCollectorOptions(oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		if strings.Contains(strings.Join(args, " "), "stuck") {
+			return stuckRunner(stuck, canceled)
+		}
+		return testLogRunner
+	})))
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- agent.Run()
+	}()
+
+	// wait for the collectors to get stuck in the first collection
+	for range 3 {
+		<-stuck
+	}
+
+	started := time.Now()
+	writeConfig("bar")
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- agent.Reload(context.Background())
+	}()
+
+	// the running collectors are served while the reload waits for the stuck ones
+	for range 3 {
+		<-canceled
+	}
+	collectors := make(chan int)
+	go func() {
+		collectors <- len(agent.Collectors())
+	}()
+	select {
+	case n := <-collectors:
+		assert.Equal(t, 4, n)
+	case <-time.After(500 * time.Millisecond):
+		t.Error("the collectors are locked while the reload waits for the stuck ones")
+	}
+
+	require.NoError(t, <-reloaded)
+	assert.Less(t, time.Since(started), 2*time.Second, "the stuck collectors share one shutdown_timeout")
+
+	agent.ShutdownCh <- struct{}{}
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,531 @@
+package oslog_collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+const (
+	logArchiveExtension = ".logarchive"
+
+	archiveStatusDone   = "done"
+	archiveStatusFailed = "failed"
+)
+
+// ArchiveState is the content of the state file of an archive watcher.
+type ArchiveState struct {
+	// Processed maps the bundle name to the result of processing it
+	Processed map[string]ProcessedArchive `json:"processed"`
+}
+
+type ProcessedArchive struct {
+	Status      string `json:"status"`
+	ProcessedAt string `json:"processed_at"`
+	Error       string `json:"error,omitempty"`
+	// ModTime and Size are the newest modification time and the total size of the files in the bundle,
+	// which tell a new bundle dropped with the same name from the processed one
+	ModTime string `json:"mod_time,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+}
+
+// archiveFingerprint is the newest modification time and the total size of the files in a bundle.
+type archiveFingerprint struct {
+	modTime time.Time
+	size    int64
+}
+
+type archiveObservation struct {
+	fingerprint archiveFingerprint
+	since       time.Time
+}
+
+// ArchiveWatcher watches a directory and runs newly appearing .logarchive bundles through the log command.
+type ArchiveWatcher struct {
+	Name          string
+	Directory     string
+	Predicate     string
+	OutputFile    string
+	StateFile     string
+	Interval      int
+	StableSeconds int
+	MoveProcessed bool
+	WithInfoLevel bool
+
+	logCommandRunnerGenerator LogCommandRunnerGenerator
+	logFile                   outputFile
+	stateLock                 *fileLock
+	metrics                   *Metrics
+	commandLimiter            *CommandLimiter
+	pipeline                  pipeline
+	logger                    *slog.Logger
+	mu                        sync.Mutex
+
+	state ArchiveState
+	// observationsMu guards observations, which are read by PendingArchives from other goroutines
+	observationsMu sync.Mutex
+	observations   map[string]archiveObservation
+	// statusMu guards result, which is read by Status from other goroutines
+	statusMu sync.Mutex
+	result   collectorResult
+}
+
+type ArchiveWatcherOption func(*ArchiveWatcher)
+
+func WithArchiveWatcherLogCommandRunner(generator LogCommandRunnerGenerator) ArchiveWatcherOption {
+	return func(w *ArchiveWatcher) {
+		w.logCommandRunnerGenerator = generator
+	}
+}
+
+// WithArchiveWatcherMetrics records the processed bundles of the watcher to the metrics.
+func WithArchiveWatcherMetrics(metrics *Metrics) ArchiveWatcherOption {
+	return func(w *ArchiveWatcher) {
+		w.metrics = metrics
+	}
+}
+
+// WithArchiveWatcherCommandLimiter limits the log commands of the watcher together with the others sharing the limiter.
+func WithArchiveWatcherCommandLimiter(limiter *CommandLimiter) ArchiveWatcherOption {
+	return func(w *ArchiveWatcher) {
+		w.commandLimiter = limiter
+	}
+}
+
+// withStateLock hands over the lock of the state file held by a watcher being replaced on reload,
+// which is kept held by the replaced watcher if the new one fails to be created.
+func withStateLock(lock *fileLock) ArchiveWatcherOption {
+	return func(w *ArchiveWatcher) {
+		w.stateLock = lock
+	}
+}
+
+func NewArchiveWatcher(config ArchiveWatcherConfig, opts ...ArchiveWatcherOption) (*ArchiveWatcher, error) {
+	logger := slog.Default().With("collector_name", config.Name)
+	watcher := &ArchiveWatcher{
+		Name:                      config.Name,
+		Directory:                 config.Directory,
+		Predicate:                 config.Predicate,
+		OutputFile:                config.OutputFile,
+		StateFile:                 config.StateFile,
+		Interval:                  config.Interval,
+		StableSeconds:             config.StableSeconds,
+		MoveProcessed:             config.MoveProcessed,
+		WithInfoLevel:             config.WithInfoLevel,
+		logCommandRunnerGenerator: newLogCommandRunner(logger),
+		observations:              map[string]archiveObservation{},
+		pipeline:                  pipeline{name: config.Name},
+		logger:                    logger,
+		result:                    collectorResult{firstDue: flextime.Now()},
+	}
+
+	for _, opt := range opts {
+		opt(watcher)
+	}
+
+	// the config of an embedding program is not validated by validateConfig
+	if watcher.StateFile == "" {
+		return nil, fmt.Errorf("state_file is required")
+	}
+
+	handedOver := watcher.stateLock != nil
+	if !handedOver {
+		lock, err := lockStateFile(watcher.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		watcher.stateLock = lock
+	}
+
+	fail := func(err error) (*ArchiveWatcher, error) {
+		if handedOver {
+			watcher.stateLock = nil
+		}
+		watcher.Close()
+		return nil, err
+	}
+
+	if err := watcher.loadState(); err != nil {
+		return fail(err)
+	}
+
+	if err := watcher.OpenLogFile(); err != nil {
+		return fail(err)
+	}
+
+	outs, procs, err := newPlugins(config.Outputs, config.Processors)
+	if err != nil {
+		return fail(err)
+	}
+	watcher.pipeline.outputs = outs
+	watcher.pipeline.processors = procs
+
+	return watcher, nil
+}
+
+// StartArchiveWatchers starts the archive watchers in the background.
+// It will run until the context is canceled.
+func StartArchiveWatchers(ctx context.Context, watchers []*ArchiveWatcher) {
+	var wg sync.WaitGroup
+	for _, watcher := range watchers {
+		wg.Add(1)
+
+		go func(w *ArchiveWatcher) {
+			defer wg.Done()
+			runArchiveWatcher(ctx, w)
+		}(watcher)
+	}
+
+	wg.Wait()
+}
+
+// runArchiveWatcher scans the watched directory at startup and then every interval until the context is canceled.
+func runArchiveWatcher(ctx context.Context, w *ArchiveWatcher) {
+	interval := time.Duration(w.Interval) * time.Second
+	timer := flextime.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		err := w.ProcessNewArchives(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		w.recordResult(err)
+		if err != nil {
+			w.logger.Error("Error processing log archives", "error", err)
+		}
+
+		timer.Reset(interval)
+	}
+}
+
+func (w *ArchiveWatcher) taskKey() string {
+	return archiveWatcherTaskKey(w.Name)
+}
+
+func (w *ArchiveWatcher) run(ctx context.Context) {
+	runArchiveWatcher(ctx, w)
+}
+
+func (w *ArchiveWatcher) taskLogger() *slog.Logger {
+	return w.logger
+}
+
+func (w *ArchiveWatcher) lockedFile() string {
+	return w.StateFile
+}
+
+func (w *ArchiveWatcher) heldLock() *fileLock {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.stateLock
+}
+
+func (w *ArchiveWatcher) handOverLock() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stateLock = nil
+}
+
+// ProcessNewArchives scans the watched directory once and processes every bundle
+// whose size has not changed for StableSeconds and that has not been processed yet.
+// An error of a bundle is logged and does not stop the others from being processed.
+func (w *ArchiveWatcher) ProcessNewArchives(ctx context.Context) error {
+	entries, err := os.ReadDir(w.Directory)
+	if err != nil {
+		return fmt.Errorf("error reading directory: %v", err)
+	}
+
+	now := flextime.Now()
+	seen := map[string]struct{}{}
+	present := map[string]struct{}{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasSuffix(name, logArchiveExtension) {
+			continue
+		}
+		present[name] = struct{}{}
+
+		fingerprint, err := newArchiveFingerprint(filepath.Join(w.Directory, name))
+		if err != nil {
+			w.logger.Error("Error processing log archive", "archive", name, "error", err)
+			continue
+		}
+		if w.processed(name, fingerprint) {
+			continue
+		}
+
+		seen[name] = struct{}{}
+
+		if !w.observe(name, fingerprint, now) {
+			continue
+		}
+
+		if err := w.processArchive(ctx, name, fingerprint); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			w.logger.Error("Error processing log archive", "archive", name, "error", err)
+		}
+	}
+
+	w.observationsMu.Lock()
+	// forget bundles that disappeared before they became stable
+	for name := range w.observations {
+		if _, ok := seen[name]; !ok {
+			delete(w.observations, name)
+		}
+	}
+	w.metrics.setArchiveQueueDepth(w.Name, len(w.observations))
+	w.observationsMu.Unlock()
+
+	return w.pruneState(present)
+}
+
+// pruneState forgets the processed bundles that are no longer in the watched directory, because they were removed
+// or moved to done/ or failed/, so that the state file does not grow forever.
+func (w *ArchiveWatcher) pruneState(present map[string]struct{}) error {
+	pruned := false
+	for name := range w.state.Processed {
+		if _, ok := present[name]; !ok {
+			delete(w.state.Processed, name)
+			pruned = true
+		}
+	}
+
+	if !pruned {
+		return nil
+	}
+	return w.saveState()
+}
+
+// observe records the fingerprint of the bundle, and reports whether it has not changed for StableSeconds.
+// A stable bundle is forgotten, and is observed again if it is not processed.
+func (w *ArchiveWatcher) observe(name string, fingerprint archiveFingerprint, now time.Time) bool {
+	w.observationsMu.Lock()
+	defer w.observationsMu.Unlock()
+
+	observation, ok := w.observations[name]
+	if !ok || observation.fingerprint != fingerprint {
+		w.observations[name] = archiveObservation{fingerprint: fingerprint, since: now}
+		return false
+	}
+
+	if now.Sub(observation.since) < time.Duration(w.StableSeconds)*time.Second {
+		return false
+	}
+
+	delete(w.observations, name)
+	return true
+}
+
+// PendingArchives returns the names of the bundles that are waiting to become stable.
+func (w *ArchiveWatcher) PendingArchives() []string {
+	w.observationsMu.Lock()
+	defer w.observationsMu.Unlock()
+
+	names := make([]string, 0, len(w.observations))
+	for name := range w.observations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// processed reports whether the bundle has been processed with the same size and modification time.
+func (w *ArchiveWatcher) processed(name string, fingerprint archiveFingerprint) bool {
+	processed, ok := w.state.Processed[name]
+	if !ok {
+		return false
+	}
+	return processed.ModTime == fingerprint.modTime.Format(time.RFC3339Nano) && processed.Size == fingerprint.size
+}
+
+func (w *ArchiveWatcher) processArchive(ctx context.Context, name string, fingerprint archiveFingerprint) error {
+	path := filepath.Join(w.Directory, name)
+
+	command := NewLogCommandBuilder().
+		WithArchive(path).WithPredicate(w.Predicate).
+		WithStyle(defaultStyle).WithInfoLevel(w.WithInfoLevel).
+		Build()
+
+	status := archiveStatusDone
+	var processErr error
+
+	release, err := w.commandLimiter.acquire(ctx, 0)
+	if err != nil {
+		return fmt.Errorf("error waiting for a log command slot: %w", err)
+	}
+	output, err := w.logCommandRunnerGenerator(command).RunLogCommand(ctx)
+	release()
+	if err != nil && ctx.Err() != nil {
+		// the bundle is processed again on the next start instead of being recorded as failed
+		return fmt.Errorf("error executing log command: %v", err)
+	} else if err != nil {
+		processErr = fmt.Errorf("error executing log command: %v, output: %s", err, string(output))
+	} else if err := w.pipeline.deliver(ctx, name+" "+fingerprint.modTime.Format(time.RFC3339Nano), "", "", output); err != nil {
+		// the bundle is processed again when it is observed to be stable again
+		return err
+	} else if err := w.logFile.write(output); err != nil {
+		return err
+	} else {
+		w.pipeline.commit()
+	}
+
+	w.metrics.observeArchive(w.Name, processErr)
+	if processErr != nil {
+		status = archiveStatusFailed
+		w.logger.Error("Error processing log archive", "archive", name, "error", processErr)
+	} else {
+		w.logger.Info("Processed log archive", "archive", name)
+	}
+
+	processed := ProcessedArchive{
+		Status:      status,
+		ProcessedAt: flextime.Now().Format(PositionTimeFormat),
+		ModTime:     fingerprint.modTime.Format(time.RFC3339Nano),
+		Size:        fingerprint.size,
+	}
+	if processErr != nil {
+		processed.Error = processErr.Error()
+	}
+	w.state.Processed[name] = processed
+
+	if err := w.saveState(); err != nil {
+		return err
+	}
+
+	if w.MoveProcessed {
+		return moveArchive(path, filepath.Join(w.Directory, status, name))
+	}
+
+	return nil
+}
+
+// OpenLogFile opens the output file, or does nothing if the watcher has no output file but outputs.
+func (w *ArchiveWatcher) OpenLogFile() error {
+	return w.logFile.open(w.OutputFile)
+}
+
+// Close closes the output file and the outputs of the watcher and releases the lock of the state file.
+func (w *ArchiveWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	errs := []error{w.logFile.close(), closeOutputs(w.pipeline.outputs)}
+	w.pipeline.outputs = nil
+
+	if w.stateLock != nil {
+		errs = append(errs, w.stateLock.Unlock())
+		w.stateLock = nil
+	}
+
+	return errors.Join(errs...)
+}
+
+// Flush commits the output file to the disk.
+func (w *ArchiveWatcher) Flush() error {
+	return w.logFile.sync()
+}
+
+func (w *ArchiveWatcher) loadState() error {
+	w.state = ArchiveState{Processed: map[string]ProcessedArchive{}}
+
+	data, err := os.ReadFile(w.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading state file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &w.state); err != nil {
+		return fmt.Errorf("error parsing state file: %v", err)
+	}
+
+	if w.state.Processed == nil {
+		w.state.Processed = map[string]ProcessedArchive{}
+	}
+	return nil
+}
+
+func (w *ArchiveWatcher) saveState() error {
+	data, err := json.Marshal(w.state)
+	if err != nil {
+		return fmt.Errorf("error marshaling state: %v", err)
+	}
+
+	if err := writeFileAtomic(w.StateFile, data, 0644); err != nil {
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+
+	return nil
+}
+
+// newArchiveFingerprint returns the newest modification time of the bundle and the files and directories in it,
+// and the total size of the files.
+func newArchiveFingerprint(path string) (archiveFingerprint, error) {
+	var fingerprint archiveFingerprint
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(fingerprint.modTime) {
+			fingerprint.modTime = info.ModTime()
+		}
+		if !d.IsDir() {
+			fingerprint.size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return archiveFingerprint{}, fmt.Errorf("error reading log archive: %v", err)
+	}
+	return fingerprint, nil
+}
+
+// moveArchive moves the bundle to dst, or to dst with a numbered suffix such as foo-1.logarchive if dst already exists.
+func moveArchive(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %v", err)
+	}
+
+	base := strings.TrimSuffix(dst, logArchiveExtension)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dst); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("error moving log archive: %v", err)
+		}
+		dst = fmt.Sprintf("%s-%d%s", base, i, logArchiveExtension)
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("error moving log archive: %v", err)
+	}
+
+	return nil
+}
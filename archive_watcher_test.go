@@ -0,0 +1,311 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveWatcher_ProcessNewArchives(t *testing.T) {
+	testCases := map[string]struct {
+		runner        oslog_collector.LogCommandRunner
+		moveProcessed bool
+		expectStatus  string
+		expectLogs    string
+		expectPath    string
+	}{
+		"when log command succeeds": {
+			runner:       testLogRunner,
+			expectStatus: "done",
+			expectLogs:   "test log ",
+			expectPath:   "test.logarchive",
+		},
+		"when log command succeeds and move_processed is enabled": {
+			runner:        testLogRunner,
+			moveProcessed: true,
+			expectStatus:  "done",
+			expectLogs:    "test log ",
+			expectPath:    filepath.Join("done", "test.logarchive"),
+		},
+		"when log command fails and move_processed is enabled": {
+			runner:        failingRunner,
+			moveProcessed: true,
+			expectStatus:  "failed",
+			expectLogs:    "",
+			expectPath:    filepath.Join("failed", "test.logarchive"),
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			flextime.Set(time.Date(2025, 1, 29, 0, 0, 0, 0, time.Now().Location()))
+			defer flextime.Restore()
+
+			workdir := t.TempDir()
+			dropDir := filepath.Join(workdir, "drop")
+			bundle := filepath.Join(dropDir, "test.logarchive")
+			require.NoError(t, os.MkdirAll(bundle, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(bundle, "Info.plist"), []byte("partial"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dropDir, "not-an-archive.txt"), []byte(""), 0644))
+
+			cfg := oslog_collector.ArchiveWatcherConfig{
+				Name:          "test",
+				Directory:     dropDir,
+				Predicate:     "eventMessage contains[cd] \"test\"",
+				OutputFile:    filepath.Join(workdir, "archive.log"),
+				StateFile:     filepath.Join(workdir, "archive.state"),
+				Interval:      1,
+				StableSeconds: 10,
+				MoveProcessed: tt.moveProcessed,
+			}
+
+			runs := 0
+			dummyRunnerGenerator := func(args []string) oslog_collector.LogCommandRunner {
+				runs++
+				assert.Equal(t, []string{"log", "show", "--archive", bundle, "--predicate", "eventMessage contains[cd] \"test\"", "--style", "ndjson"}, args)
+				return tt.runner
+			}
+
+			watcher, err := oslog_collector.NewArchiveWatcher(cfg, oslog_collector.WithArchiveWatcherLogCommandRunner(dummyRunnerGenerator))
+			require.NoError(t, err)
+
+			// the bundle is seen for the first time
+			require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+			assert.Equal(t, 0, runs)
+			assert.Equal(t, []string{"test.logarchive"}, watcher.PendingArchives())
+
+			// the bundle is still being copied
+			flextime.Set(flextime.Now().Add(10 * time.Second))
+			require.NoError(t, os.WriteFile(filepath.Join(bundle, "Info.plist"), []byte("complete"), 0644))
+			require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+			assert.Equal(t, 0, runs)
+
+			// the bundle has not changed for stable_seconds
+			flextime.Set(flextime.Now().Add(10 * time.Second))
+			require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+			assert.Equal(t, 1, runs)
+			assert.Empty(t, watcher.PendingArchives())
+			assert.Equal(t, tt.expectStatus, readArchiveState(t, cfg.StateFile).Processed["test.logarchive"].Status)
+
+			// processed bundles are not processed again
+			flextime.Set(flextime.Now().Add(10 * time.Second))
+			require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+			assert.Equal(t, 1, runs)
+
+			_, err = os.Stat(filepath.Join(dropDir, tt.expectPath))
+			assert.NoError(t, err)
+
+			logs, err := os.ReadFile(cfg.OutputFile)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectLogs, string(logs))
+
+			// moved bundles are forgotten from the state file
+			_, recorded := readArchiveState(t, cfg.StateFile).Processed["test.logarchive"]
+			assert.Equal(t, !tt.moveProcessed, recorded)
+
+			// the state file is loaded on restart
+			require.NoError(t, watcher.Close())
+			restarted, err := oslog_collector.NewArchiveWatcher(cfg, oslog_collector.WithArchiveWatcherLogCommandRunner(dummyRunnerGenerator))
+			require.NoError(t, err)
+			require.NoError(t, restarted.ProcessNewArchives(context.Background()))
+			assert.Empty(t, restarted.PendingArchives())
+			require.NoError(t, restarted.Close())
+		})
+	}
+}
+
+func TestArchiveWatcher_ProcessNewArchives_ReusedName(t *testing.T) {
+	flextime.Set(time.Date(2025, 1, 29, 0, 0, 0, 0, time.Now().Location()))
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	dropDir := filepath.Join(workdir, "drop")
+	cfg := oslog_collector.ArchiveWatcherConfig{
+		Name:          "test",
+		Directory:     dropDir,
+		OutputFile:    filepath.Join(workdir, "archive.log"),
+		StateFile:     filepath.Join(workdir, "archive.state"),
+		Interval:      1,
+		StableSeconds: 10,
+		MoveProcessed: true,
+	}
+
+	runs := 0
+	watcher, err := oslog_collector.NewArchiveWatcher(cfg, oslog_collector.WithArchiveWatcherLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		runs++
+		return testLogRunner
+	}))
+	require.NoError(t, err)
+	defer watcher.Close()
+
+	drop := func(modTime time.Time) {
+		t.Helper()
+		bundle := filepath.Join(dropDir, "test.logarchive")
+		require.NoError(t, os.MkdirAll(bundle, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(bundle, "Info.plist"), []byte("complete"), 0644))
+		require.NoError(t, os.Chtimes(bundle, modTime, modTime))
+
+		require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+		flextime.Set(flextime.Now().Add(10 * time.Second))
+		require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+	}
+
+	drop(time.Date(2025, 1, 28, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, 1, runs)
+
+	// a new bundle with the same name is processed and moved next to the processed one
+	drop(time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, 2, runs)
+
+	for _, name := range []string{"test.logarchive", "test-1.logarchive"} {
+		_, err := os.Stat(filepath.Join(dropDir, "done", name))
+		assert.NoError(t, err)
+	}
+}
+
+func TestArchiveWatcher_ProcessNewArchives_ContinuesAfterError(t *testing.T) {
+	flextime.Set(time.Date(2025, 1, 29, 0, 0, 0, 0, time.Now().Location()))
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	dropDir := filepath.Join(workdir, "drop")
+	for _, name := range []string{"a.logarchive", "b.logarchive"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dropDir, name), 0755))
+	}
+	// a file in place of the done directory makes moving the bundles fail
+	require.NoError(t, os.WriteFile(filepath.Join(dropDir, "done"), nil, 0644))
+
+	cfg := oslog_collector.ArchiveWatcherConfig{
+		Name:          "test",
+		Directory:     dropDir,
+		OutputFile:    filepath.Join(workdir, "archive.log"),
+		StateFile:     filepath.Join(workdir, "archive.state"),
+		Interval:      1,
+		StableSeconds: 10,
+		MoveProcessed: true,
+	}
+
+	runs := 0
+	watcher, err := oslog_collector.NewArchiveWatcher(cfg, oslog_collector.WithArchiveWatcherLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		runs++
+		return testLogRunner
+	}))
+	require.NoError(t, err)
+	defer watcher.Close()
+
+	require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+	flextime.Set(flextime.Now().Add(10 * time.Second))
+	require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+
+	assert.Equal(t, 2, runs)
+}
+
+func TestArchiveWatcher_ProcessNewArchives_ChangedFile(t *testing.T) {
+	flextime.Set(time.Date(2025, 1, 29, 0, 0, 0, 0, time.Now().Location()))
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	dropDir := filepath.Join(workdir, "drop")
+	bundle := filepath.Join(dropDir, "test.logarchive", "Persist")
+	require.NoError(t, os.MkdirAll(bundle, 0755))
+
+	cfg := oslog_collector.ArchiveWatcherConfig{
+		Name:          "test",
+		Directory:     dropDir,
+		OutputFile:    filepath.Join(workdir, "archive.log"),
+		StateFile:     filepath.Join(workdir, "archive.state"),
+		Interval:      1,
+		StableSeconds: 10,
+	}
+
+	runs := 0
+	watcher, err := oslog_collector.NewArchiveWatcher(cfg, oslog_collector.WithArchiveWatcherLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		runs++
+		return testLogRunner
+	}))
+	require.NoError(t, err)
+	defer watcher.Close()
+
+	// the bundle directories keep their modification time, and only the nested file is replaced
+	write := func(data string, modTime time.Time) {
+		t.Helper()
+		file := filepath.Join(bundle, "0000000000000001.tracev3")
+		require.NoError(t, os.WriteFile(file, []byte(data), 0644))
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+		for _, dir := range []string{bundle, filepath.Dir(bundle)} {
+			require.NoError(t, os.Chtimes(dir, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+		}
+
+		require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+		flextime.Set(flextime.Now().Add(10 * time.Second))
+		require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+	}
+
+	write("first", time.Date(2025, 1, 28, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, 1, runs)
+
+	write("first", time.Date(2025, 1, 28, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, 1, runs, "unchanged bundle is not processed again")
+
+	write("second", time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, 2, runs, "bundle with a changed file is processed again")
+}
+
+func TestArchiveWatcher_ProcessNewArchives_RemovedBundle(t *testing.T) {
+	flextime.Set(time.Date(2025, 1, 29, 0, 0, 0, 0, time.Now().Location()))
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	dropDir := filepath.Join(workdir, "drop")
+	for _, name := range []string{"a.logarchive", "b.logarchive"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dropDir, name), 0755))
+	}
+
+	cfg := oslog_collector.ArchiveWatcherConfig{
+		Name:          "test",
+		Directory:     dropDir,
+		OutputFile:    filepath.Join(workdir, "archive.log"),
+		StateFile:     filepath.Join(workdir, "archive.state"),
+		Interval:      1,
+		StableSeconds: 10,
+	}
+
+	watcher, err := oslog_collector.NewArchiveWatcher(cfg, oslog_collector.WithArchiveWatcherLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		return testLogRunner
+	}))
+	require.NoError(t, err)
+	defer watcher.Close()
+
+	require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+	flextime.Set(flextime.Now().Add(10 * time.Second))
+	require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+	assert.Len(t, readArchiveState(t, cfg.StateFile).Processed, 2)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(dropDir, "a.logarchive")))
+	require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+
+	state := readArchiveState(t, cfg.StateFile)
+	assert.NotContains(t, state.Processed, "a.logarchive", "removed bundle is forgotten")
+	assert.Contains(t, state.Processed, "b.logarchive")
+}
+
+func readArchiveState(t *testing.T, stateFile string) oslog_collector.ArchiveState {
+	t.Helper()
+
+	data, err := os.ReadFile(stateFile)
+	require.NoError(t, err)
+
+	var state oslog_collector.ArchiveState
+	require.NoError(t, json.Unmarshal(data, &state))
+	return state
+}
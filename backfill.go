@@ -0,0 +1,88 @@
+package oslog_collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var defaultBackfillChunk = time.Hour
+
+type BackfillOptions struct {
+	// From is the start of the range to collect
+	From time.Time
+	// To is the end of the range to collect
+	To time.Time
+	// Chunk is the length of the window of each log command, which is 1 hour by default
+	Chunk time.Duration
+	// OutputFile overrides the output file of the collector, which is required while the agent is running the collector
+	OutputFile string
+	// Plugins delivers the logs to the outputs of the collector even while the agent is running the collector,
+	// which are skipped by default not to mix historical logs into the live ones
+	Plugins bool
+}
+
+// Backfill collects the logs of the collector from opts.From to opts.To in chunks. The batches delivered to the outputs
+// and the sink are marked as LogBatch.Backfill.
+// It does not read or write the position file, so it can run while the agent is running the same collector, which
+// is detected by the lock of the position file. In that case, the logs must be written to another output file so that
+// they are not interleaved with the ones written by the agent.
+func Backfill(ctx context.Context, config OSLogCollectorConfig, opts BackfillOptions, collectorOpts ...OSLogCollectorOption) error {
+	if !opts.From.Before(opts.To) {
+		return fmt.Errorf("from (%s) must be before to (%s)", opts.From.Format(LogCommandTimeFormat), opts.To.Format(LogCommandTimeFormat))
+	}
+
+	chunk := opts.Chunk
+	if chunk == 0 {
+		chunk = defaultBackfillChunk
+	}
+	if chunk < time.Second {
+		return fmt.Errorf("chunk must be at least 1 second")
+	}
+
+	running, err := isStateFileLocked(config.PositionFile)
+	if err != nil {
+		return err
+	}
+	if running && (opts.OutputFile == "" || opts.OutputFile == config.OutputFile) {
+		return fmt.Errorf("collector %s is running in the agent, set another output file to backfill into", config.Name)
+	}
+
+	collector := newOSLogCollector(config, collectorOpts...)
+	collector.pipeline.backfill = true
+	if opts.OutputFile != "" {
+		collector.OutputFile = opts.OutputFile
+	}
+
+	if err := collector.OpenLogFile(); err != nil {
+		return err
+	}
+	defer collector.Close()
+
+	if running && !opts.Plugins {
+		config.Outputs, config.Processors = nil, nil
+	}
+	if err := collector.openPlugins(config); err != nil {
+		return err
+	}
+
+	for start := opts.From; start.Before(opts.To); start = start.Add(chunk) {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("backfill interrupted before %s: %w", start.Format(LogCommandTimeFormat), err)
+		}
+
+		end := start.Add(chunk)
+		if end.After(opts.To) {
+			end = opts.To
+		}
+
+		startTime, endTime := start.Format(PositionTimeFormat), end.Format(PositionTimeFormat)
+		if err := collector.collectWindow(ctx, startTime, endTime); err != nil {
+			return fmt.Errorf("error backfilling from %s to %s: %w", startTime, endTime, err)
+		}
+
+		collector.logger.Info("Backfilled logs", "start", startTime, "end", endTime, "output_file", collector.OutputFile)
+	}
+
+	return nil
+}
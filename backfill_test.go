@@ -0,0 +1,152 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfill(t *testing.T) {
+	t.Parallel()
+
+	from := time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		opts             oslog_collector.BackfillOptions
+		expectWindows    [][2]string
+		expectErr        bool
+		expectErrMessage string
+	}{
+		"when range is split into chunks": {
+			opts: oslog_collector.BackfillOptions{
+				From:  from,
+				To:    from.Add(150 * time.Minute),
+				Chunk: time.Hour,
+			},
+			expectWindows: [][2]string{
+				{"2025-01-29 00:00:00+0000", "2025-01-29 01:00:00+0000"},
+				{"2025-01-29 01:00:00+0000", "2025-01-29 02:00:00+0000"},
+				{"2025-01-29 02:00:00+0000", "2025-01-29 02:30:00+0000"},
+			},
+		},
+		"when chunk is not specified then 1 hour is used": {
+			opts: oslog_collector.BackfillOptions{
+				From: from,
+				To:   from.Add(90 * time.Minute),
+			},
+			expectWindows: [][2]string{
+				{"2025-01-29 00:00:00+0000", "2025-01-29 01:00:00+0000"},
+				{"2025-01-29 01:00:00+0000", "2025-01-29 01:30:00+0000"},
+			},
+		},
+		"when from is after to": {
+			opts: oslog_collector.BackfillOptions{
+				From: from,
+				To:   from.Add(-time.Hour),
+			},
+			expectErr:        true,
+			expectErrMessage: "from (2025-01-29 00:00:00+0000) must be before to (2025-01-28 23:00:00+0000)",
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			workdir := t.TempDir()
+			cfg := oslog_collector.OSLogCollectorConfig{
+				Name:         "test",
+				Predicate:    "process == 'test'",
+				OutputFile:   filepath.Join(workdir, "test.log"),
+				PositionFile: filepath.Join(workdir, "test.pos"),
+				Interval:     60,
+			}
+
+			var windows [][2]string
+			dummyRunnerGeenerator := func(args []string) oslog_collector.LogCommandRunner {
+				windows = append(windows, [2]string{args[5], args[7]})
+				return testLogRunner
+			}
+
+			opts := tt.opts
+			opts.OutputFile = filepath.Join(workdir, "backfill.log")
+
+			err := oslog_collector.Backfill(context.Background(), cfg, opts, oslog_collector.WithLogCommandRunner(dummyRunnerGeenerator))
+			if tt.expectErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMessage)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectWindows, windows)
+
+			logs, err := os.ReadFile(opts.OutputFile)
+			require.NoError(t, err)
+			assert.Len(t, logs, len(tt.expectWindows)*len("test log "))
+
+			// the live output file and position file are not touched
+			for _, file := range []string{cfg.OutputFile, cfg.PositionFile} {
+				_, err := os.Stat(file)
+				assert.True(t, os.IsNotExist(err))
+			}
+		})
+	}
+}
+
+func TestBackfill_RunningAgent(t *testing.T) {
+	workdir := t.TempDir()
+	from := time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC)
+
+	cfg, err := oslog_collector.ParseConfig([]byte(`
+collectors:
+  - name: test
+    predicate: "process == 'test'"
+    output_file: ` + filepath.Join(workdir, "test.log") + `
+    position_file: ` + filepath.Join(workdir, "test.pos") + `
+    interval: 60
+    outputs:
+      - type: test_recording
+`))
+	require.NoError(t, err)
+	collectorCfg := cfg.Collectors[0]
+
+	runner := oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		return outputRunner(entriesOutput)
+	})
+
+	// the running collector holds the lock of the position file
+	running, err := oslog_collector.NewOSLogCollector(collectorCfg, runner)
+	require.NoError(t, err)
+	defer running.Close()
+
+	opts := oslog_collector.BackfillOptions{From: from, To: from.Add(time.Hour)}
+	err = oslog_collector.Backfill(context.Background(), collectorCfg, opts, runner)
+	assert.EqualError(t, err, "collector test is running in the agent, set another output file to backfill into")
+
+	var batches []*oslog_collector.LogBatch
+	sink := oslog_collector.WithSink(func(ctx context.Context, batch *oslog_collector.LogBatch) error {
+		batches = append(batches, batch)
+		return nil
+	})
+
+	createdOutputs = nil
+	opts.OutputFile = filepath.Join(workdir, "backfill.log")
+	require.NoError(t, oslog_collector.Backfill(context.Background(), collectorCfg, opts, runner, sink))
+	assert.Empty(t, createdOutputs, "the outputs are skipped while the agent is running")
+	require.Len(t, batches, 1)
+	assert.True(t, batches[0].Backfill)
+
+	opts.Plugins = true
+	require.NoError(t, oslog_collector.Backfill(context.Background(), collectorCfg, opts, runner))
+	require.Len(t, createdOutputs, 1)
+	assert.Equal(t, []string{"foo", "bar"}, createdOutputs[0].messages)
+}
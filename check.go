@@ -0,0 +1,149 @@
+package oslog_collector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Songmu/flextime"
+)
+
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// CheckConfigFile validates the config file and the files used by the agent without starting it,
+// and writes the log command each collector would execute for its next window to w.
+// Unlike LoadConfigFromFile, it returns all errors found joined instead of the first one.
+func CheckConfigFile(w io.Writer, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{validateConfig(config)}
+
+	if config.PIDFile != "" {
+		errs = append(errs, prefixError("pid_file", checkWritableFile(config.PIDFile)))
+	}
+
+	now := flextime.Now().Format(PositionTimeFormat)
+
+	for _, c := range config.Collectors {
+		errs = append(errs,
+			prefixError("collector "+c.Name+": output_file", checkWritableFile(c.OutputFile)),
+			prefixError("collector "+c.Name+": position_file", checkStateFile(c.PositionFile)),
+		)
+
+		collector := newOSLogCollector(c)
+		if err := collector.loadPosition(); err != nil {
+			errs = append(errs, fmt.Errorf("collector %s: %v", c.Name, err))
+			continue
+		}
+
+		// the first window of the agent is limited to the catch-up window if the position is far behind
+		collector.detectClockJump()
+		command := collector.newLogCommandBuilder(collector.LastTimestamp, collector.windowEnd(now)).Build()
+		fmt.Fprintf(w, "collector %s:\n  %s\n", c.Name, shellJoin(command))
+	}
+
+	for _, watcher := range config.ArchiveWatchers {
+		errs = append(errs,
+			prefixError("archive watcher "+watcher.Name+": directory", checkReadableDir(watcher.Directory)),
+			prefixError("archive watcher "+watcher.Name+": output_file", checkWritableFile(watcher.OutputFile)),
+			prefixError("archive watcher "+watcher.Name+": state_file", checkStateFile(watcher.StateFile)),
+		)
+
+		fmt.Fprintf(w, "archive watcher %s:\n  watching %s\n", watcher.Name, watcher.Directory)
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkWritableFile checks that the file can be written, or created if it does not exist.
+func checkWritableFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %v", path, err)
+		}
+		return file.Close()
+	}
+
+	return checkWritableDir(filepath.Dir(path))
+}
+
+// checkStateFile checks that a position or state file can be replaced and locked. The directory must be writable
+// even if the file exists, because the file is replaced by renaming a temporary file and locked with the lock file
+// next to it.
+func checkStateFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	if err := checkWritableDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	lockPath := stateLockPath(path)
+	if _, err := os.Stat(lockPath); err == nil {
+		file, err := os.OpenFile(lockPath, os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %v", lockPath, err)
+		}
+		return file.Close()
+	}
+	return nil
+}
+
+// checkWritableDir checks that a file can be created in the directory.
+func checkWritableDir(dir string) error {
+	file, err := os.CreateTemp(dir, ".oslog-collector-check-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %v", dir, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func checkReadableDir(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	if _, err := os.ReadDir(path); err != nil {
+		return fmt.Errorf("%s is not readable: %v", path, err)
+	}
+	return nil
+}
+
+func prefixError(prefix string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", prefix, err)
+}
+
+// shellJoin joins the arguments quoting them for the shell, so that the command can be copied and run.
+func shellJoin(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if shellSafePattern.MatchString(arg) {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
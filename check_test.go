@@ -0,0 +1,119 @@
+package oslog_collector_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConfigFile(t *testing.T) {
+	flextime.Set(time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC))
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, "bar.pos"), []byte(`{"last_timestamp":"2025-01-28T23:59:00Z"}`), 0644))
+	// baz is behind by more than the catch-up window
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, "baz.pos"), []byte(`{"last_timestamp":"2025-01-28T22:00:00Z"}`), 0644))
+	// the lock file of qux cannot be opened
+	require.NoError(t, os.Mkdir(filepath.Join(workdir, "qux.pos.lock"), 0755))
+
+	testCases := map[string]struct {
+		config           string
+		expectOutput     string
+		expectErr        bool
+		expectErrMessage []string
+	}{
+		"when config is valid": {
+			config: fmt.Sprintf(`
+pid_file: %[1]s/oslog-collector.pid
+collectors:
+  - name: foo
+    predicate: "subsystem == 'com.apple.mdns'"
+    output_file: %[1]s/foo.log
+    position_file: %[1]s/foo.pos
+    interval: 60
+  - name: bar
+    predicate: "process == 'bar'"
+    output_file: %[1]s/bar.log
+    position_file: %[1]s/bar.pos
+    interval: 60
+    with_info_level: true
+  - name: baz
+    predicate: "process == 'baz'"
+    output_file: %[1]s/baz.log
+    position_file: %[1]s/baz.pos
+    interval: 60
+`, workdir),
+			expectOutput: `collector foo:
+  log show --predicate 'subsystem == '\''com.apple.mdns'\''' --start '2025-01-29 00:00:00+0000' --end '2025-01-29 00:00:00+0000' --style ndjson
+collector bar:
+  log show --predicate 'process == '\''bar'\''' --start '2025-01-28 23:59:00+0000' --end '2025-01-29 00:00:00+0000' --style ndjson --info
+collector baz:
+  log show --predicate 'process == '\''baz'\''' --start '2025-01-28 22:00:00+0000' --end '2025-01-28 23:00:00+0000' --style ndjson
+`,
+		},
+		"when config has multiple errors": {
+			config: fmt.Sprintf(`
+pid_file: %[1]s/not-exist/oslog-collector.pid
+collectors:
+  - name: foo
+    predicate: "subsystem == 'com.apple.mdns' AND"
+    output_file: %[1]s/not-exist/foo.log
+    position_file: %[1]s/foo.pos
+    interval: 0
+  - name: bar
+    predicate: "process == 'bar'"
+    output_file: %[1]s/bar.log
+    interval: 60
+  - name: qux
+    predicate: "process == 'qux'"
+    output_file: %[1]s/qux.log
+    position_file: %[1]s/qux.pos
+    interval: 60
+`, workdir),
+			expectErr: true,
+			expectErrMessage: []string{
+				"collector foo: interval must be greater than 0",
+				"collector foo: invalid predicate",
+				"collector bar: position_file is required",
+				"pid_file: directory " + filepath.Join(workdir, "not-exist") + " is not writable",
+				"collector foo: output_file: directory " + filepath.Join(workdir, "not-exist") + " is not writable",
+				"collector qux: position_file: " + filepath.Join(workdir, "qux.pos.lock") + " is not writable",
+			},
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "oslog-collector.conf")
+			require.NoError(t, os.WriteFile(configFile, []byte(tt.config), 0644))
+
+			var output bytes.Buffer
+			err := oslog_collector.CheckConfigFile(&output, configFile)
+			if tt.expectErr {
+				assert.Error(t, err)
+				for _, message := range tt.expectErrMessage {
+					assert.Contains(t, err.Error(), message)
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectOutput, output.String())
+
+			// the check does not create any files
+			_, err = os.Stat(filepath.Join(workdir, "foo.log"))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+const (
+	// clockJumpThreshold is the difference between the wall clock and the monotonic clock elapsed between cycles
+	// from which the wall clock is considered to have jumped
+	clockJumpThreshold = time.Minute
+	// defaultCatchUpWindow is the maximum length of the windows collected after the wall clock jumped forward
+	defaultCatchUpWindow = time.Hour
+)
+
+var processStart = time.Now()
+
+// monotonicNow returns the monotonic time since the process started, which does not advance while the Mac sleeps
+// and is not affected by the changes of the wall clock.
+func monotonicNow() time.Duration {
+	return time.Since(processStart)
+}
+
+// WithMonotonicClock replaces the monotonic clock compared with the wall clock of flextime to detect clock jumps.
+func WithMonotonicClock(now func() time.Duration) OSLogCollectorOption {
+	return func(c *OSLogCollector) {
+		c.monotonicNow = now
+	}
+}
+
+// clockReading is the wall clock and the monotonic clock read at the start of a cycle. The wall clock is read without
+// the monotonic reading of time.Now, with which Time.Sub would measure the monotonic clock instead of the wall clock.
+type clockReading struct {
+	wall      time.Time
+	monotonic time.Duration
+}
+
+// detectClockJump compares the wall clock and the monotonic clock elapsed since the previous cycle. If the wall clock
+// jumped forward, e.g. because the Mac slept or the clock was set forward, the following windows are limited to
+// the catch-up window until the collector catches up with now. A backward jump is only logged, and the windows
+// are skipped until the wall clock passes the position.
+// The first cycle has no previous cycle to compare with, so the windows are limited in the same way if the position
+// is behind by the threshold, e.g. after the agent was stopped for a long time.
+func (c *OSLogCollector) detectClockJump() {
+	current := clockReading{wall: flextime.Now().Round(0), monotonic: c.monotonicNow()}
+	previous := c.lastClockReading
+	c.lastClockReading = current
+
+	if previous.wall.IsZero() {
+		position, err := parseLogTimestamp(c.LastTimestamp)
+		if err != nil {
+			// the first window starts from --last
+			return
+		}
+		if lag := current.wall.Sub(position); lag >= clockJumpThreshold {
+			c.limitToCatchUpWindow("Position is behind at startup, collecting the lag in catch-up windows", "lag", lag)
+		}
+		return
+	}
+
+	jump := current.wall.Sub(previous.wall) - (current.monotonic - previous.monotonic)
+	switch {
+	case jump >= clockJumpThreshold:
+		c.limitToCatchUpWindow("Wall clock jumped forward, the Mac may have slept, collecting the gap in catch-up windows", "jump", jump)
+	case jump <= -clockJumpThreshold:
+		c.logger.Warn("Wall clock jumped backward, skipping the windows until it passes the position",
+			"jump", jump, "position", c.LastTimestamp)
+	}
+}
+
+// limitToCatchUpWindow limits the following windows to the catch-up window, unless they are already limited to
+// a shorter one after a timeout.
+func (c *OSLogCollector) limitToCatchUpWindow(msg string, args ...any) {
+	window := c.catchUpWindow()
+	c.logger.Warn(msg, append(args, "catch_up_window", window)...)
+	if c.windowLimit == 0 || window < c.windowLimit {
+		c.windowLimit = window
+	}
+}
+
+func (c *OSLogCollector) catchUpWindow() time.Duration {
+	if c.CatchUpWindow == 0 {
+		return defaultCatchUpWindow
+	}
+	return time.Duration(c.CatchUpWindow) * time.Second
+}
+
+// emptyWindow returns true if the window from the position to endTime is negative or zero-length. A negative window
+// happens while the wall clock is behind the position after it jumped backward, and a zero-length one when
+// the collector runs again in the second of the position, which has already been collected.
+func (c *OSLogCollector) emptyWindow(endTime string) bool {
+	start, err := parseLogTimestamp(c.LastTimestamp)
+	if err != nil {
+		// the first window starts from --last
+		return false
+	}
+	end, err := parseLogTimestamp(endTime)
+	if err != nil {
+		return false
+	}
+
+	if end.Before(start) {
+		c.logger.Warn("Skipping the window because the wall clock is behind the position", "start", c.LastTimestamp, "end", endTime)
+	}
+	return !end.After(start)
+}
+
+func validateCatchUpWindow(catchUpWindow int) error {
+	if catchUpWindow < 0 {
+		return fmt.Errorf("catch_up_window must not be negative")
+	}
+	return nil
+}
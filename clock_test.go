@@ -0,0 +1,170 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOSLogCollector_CollectLogs_ClockJump_MonotonicReading(t *testing.T) {
+	// the wall clock returns the times with the monotonic reading like time.Now in production
+	base := time.Now()
+	wall := base
+	defer flextime.NowFunc(func() time.Time { return wall })()
+
+	monotonic := time.Duration(0)
+
+	workdir := t.TempDir()
+	cfg := oslog_collector.OSLogCollectorConfig{
+		Name:         "test",
+		Predicate:    "process == 'test'",
+		OutputFile:   filepath.Join(workdir, "test.log"),
+		PositionFile: filepath.Join(workdir, "test.pos"),
+		Interval:     60,
+	}
+	require.NoError(t, oslog_collector.WritePosition(cfg.PositionFile, oslog_collector.Position{LastTimestamp: base.Add(-time.Minute).Format(time.RFC3339)}))
+
+	windows := 0
+	collector, err := oslog_collector.NewOSLogCollector(cfg,
+		oslog_collector.WithMonotonicClock(func() time.Duration { return monotonic }),
+		oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+			windows++
+			return testLogRunner
+		}))
+	require.NoError(t, err)
+	defer collector.Close()
+
+	require.NoError(t, collector.CollectLogs(context.Background()))
+	require.Equal(t, 1, windows)
+
+	// the Mac slept for 2.5 hours, during which the monotonic clock does not advance
+	wall = base.Add(2*time.Hour + 30*time.Minute)
+	monotonic = time.Minute
+	require.NoError(t, collector.CollectLogs(context.Background()))
+
+	assert.Equal(t, 1+3, windows, "the gap is collected in catch-up windows of an hour")
+}
+
+func TestOSLogCollector_CollectLogs_ClockJump(t *testing.T) {
+	// fakeClock moves the wall clock of flextime and the monotonic clock separately
+	type fakeClock struct {
+		wall      time.Time
+		monotonic time.Duration
+	}
+
+	start := time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		steps         []fakeClock
+		expectWindows [][2]string
+		expectPos     string
+	}{
+		"when clocks advance together then collect one window": {
+			steps: []fakeClock{
+				{wall: start.Add(time.Minute), monotonic: time.Minute},
+				{wall: start.Add(2 * time.Minute), monotonic: 2 * time.Minute},
+			},
+			expectWindows: [][2]string{
+				{"2025-01-29 00:00:00+0000", "2025-01-29 00:01:00+0000"},
+				{"2025-01-29 00:01:00+0000", "2025-01-29 00:02:00+0000"},
+			},
+			expectPos: "2025-01-29T00:02:00Z",
+		},
+		"when wall clock jumps forward then collect the gap in catch-up windows": {
+			steps: []fakeClock{
+				{wall: start.Add(time.Minute), monotonic: time.Minute},
+				// the Mac slept for 2.5 hours, during which the monotonic clock does not advance
+				{wall: start.Add(2*time.Hour + 31*time.Minute), monotonic: 2 * time.Minute},
+			},
+			expectWindows: [][2]string{
+				{"2025-01-29 00:00:00+0000", "2025-01-29 00:01:00+0000"},
+				{"2025-01-29 00:01:00+0000", "2025-01-29 01:01:00+0000"},
+				{"2025-01-29 01:01:00+0000", "2025-01-29 02:01:00+0000"},
+				{"2025-01-29 02:01:00+0000", "2025-01-29 02:31:00+0000"},
+			},
+			expectPos: "2025-01-29T02:31:00Z",
+		},
+		"when position is behind at startup then collect the lag in catch-up windows": {
+			steps: []fakeClock{
+				// the agent was stopped for 2.5 hours
+				{wall: start.Add(2*time.Hour + 30*time.Minute), monotonic: time.Minute},
+			},
+			expectWindows: [][2]string{
+				{"2025-01-29 00:00:00+0000", "2025-01-29 01:00:00+0000"},
+				{"2025-01-29 01:00:00+0000", "2025-01-29 02:00:00+0000"},
+				{"2025-01-29 02:00:00+0000", "2025-01-29 02:30:00+0000"},
+			},
+			expectPos: "2025-01-29T02:30:00Z",
+		},
+		"when collector runs again in the second of the position then skip the zero-length window": {
+			steps: []fakeClock{
+				{wall: start.Add(time.Minute), monotonic: time.Minute},
+				// triggered right after the scheduled run without any clock jump
+				{wall: start.Add(time.Minute + 500*time.Millisecond), monotonic: time.Minute + 500*time.Millisecond},
+			},
+			expectWindows: [][2]string{
+				{"2025-01-29 00:00:00+0000", "2025-01-29 00:01:00+0000"},
+			},
+			expectPos: "2025-01-29T00:01:00Z",
+		},
+		"when wall clock jumps backward then skip the windows until it passes the position": {
+			steps: []fakeClock{
+				{wall: start.Add(time.Hour), monotonic: time.Minute},
+				// the clock is set back by an hour
+				{wall: start.Add(time.Minute), monotonic: 2 * time.Minute},
+				{wall: start.Add(time.Hour), monotonic: time.Hour + time.Minute},
+				{wall: start.Add(time.Hour + time.Minute), monotonic: time.Hour + 2*time.Minute},
+			},
+			expectWindows: [][2]string{
+				{"2025-01-29 00:00:00+0000", "2025-01-29 01:00:00+0000"},
+				{"2025-01-29 01:00:00+0000", "2025-01-29 01:01:00+0000"},
+			},
+			expectPos: "2025-01-29T01:01:00Z",
+		},
+	}
+
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			defer flextime.Restore()
+
+			workdir := t.TempDir()
+			cfg := oslog_collector.OSLogCollectorConfig{
+				Name:         "test",
+				Predicate:    "process == 'test'",
+				OutputFile:   filepath.Join(workdir, "test.log"),
+				PositionFile: filepath.Join(workdir, "test.pos"),
+				Interval:     60,
+			}
+			require.NoError(t, oslog_collector.WritePosition(cfg.PositionFile, oslog_collector.Position{LastTimestamp: "2025-01-29T00:00:00Z"}))
+
+			var clock fakeClock
+			var windows [][2]string
+			collector, err := oslog_collector.NewOSLogCollector(cfg,
+				oslog_collector.WithMonotonicClock(func() time.Duration { return clock.monotonic }),
+				oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+					windows = append(windows, [2]string{args[5], args[7]})
+					return testLogRunner
+				}))
+			require.NoError(t, err)
+			defer collector.Close()
+
+			for _, step := range tt.steps {
+				clock = step
+				flextime.Fix(step.wall)
+				require.NoError(t, collector.CollectLogs(context.Background()))
+			}
+
+			assert.Equal(t, tt.expectWindows, windows)
+
+			pos, err := oslog_collector.ReadPosition(cfg.PositionFile)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectPos, pos.LastTimestamp)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+//go:build darwin
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+)
+
+// runBackfill collects the logs of a collector in an explicit time range without touching its position file.
+func runBackfill(args []string) int {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	collectorName := flags.String("collector", "", "name of the collector to backfill (required)")
+	from := flags.String("from", "", `start of the range, e.g. "2025-01-29 00:00:00" or "-6h" (required)`)
+	to := flags.String("to", "now", `end of the range, e.g. "2025-01-29 06:00:00" or "-1h"`)
+	chunk := flags.Duration("chunk", time.Hour, "length of the window of each log command")
+	output := flags.String("output", "", "file to write the logs to instead of the output_file of the collector, required while the agent is running")
+	plugins := flags.Bool("plugins", false, "deliver the logs to the outputs of the collector even while the agent is running")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s backfill -collector <name> -from <time> [-to <time>] [-chunk <duration>] [-output <file>] [-plugins] <config_file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *collectorName == "" || *from == "" {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := oslog_collector.LoadConfigFromFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return 2
+	}
+
+	i := slices.IndexFunc(config.Collectors, func(c oslog_collector.OSLogCollectorConfig) bool { return c.Name == *collectorName })
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "collector not found: %s\n", *collectorName)
+		return 2
+	}
+
+	now := flextime.Now()
+	opts := oslog_collector.BackfillOptions{Chunk: *chunk, OutputFile: *output, Plugins: *plugins}
+
+	if opts.From, err = oslog_collector.ParseTime(*from, now); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -from: %v\n", err)
+		return 2
+	}
+	if opts.To, err = oslog_collector.ParseTime(*to, now); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -to: %v\n", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := oslog_collector.Backfill(ctx, config.Collectors[i], opts); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to backfill: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,68 @@
+//go:build darwin
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+)
+
+// runCheck validates the config file and prints the log command each collector would execute for its next window,
+// or the config with the predicates expanded if -dump is set.
+// It exits with 1 and prints all errors found if the config is invalid.
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	dump := flags.Bool("dump", false, "print the config with the predicate references expanded and the matches compiled")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s check [-dump] <config_file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+	if *dump {
+		w = io.Discard
+	}
+
+	if err := oslog_collector.CheckConfigFile(w, flags.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "config check failed:")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "  - %s\n", line)
+		}
+		return 1
+	}
+
+	if *dump {
+		return dumpConfig(flags.Arg(0))
+	}
+
+	fmt.Println("config is valid")
+	return 0
+}
+
+func dumpConfig(configFile string) int {
+	config, err := oslog_collector.LoadConfigFromFile(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	data, err := config.Dump()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	os.Stdout.Write(data)
+	return 0
+}
@@ -0,0 +1,81 @@
+//go:build darwin
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+)
+
+const ctlUsage = `Usage: %[1]s ctl [-collector <name>] <config_file> <command>
+
+Commands:
+  pause                    Pause the scheduled collections
+  resume                   Resume the scheduled collections
+  trigger                  Collect the logs now
+  reopen                   Reopen the output files and the log file
+  reload                   Reload the config file
+  status                   Print the status of the collectors in JSON
+  flush                    Commit the output files to the disk
+
+pause, resume and trigger apply to all collectors unless -collector is given.
+The agent must be running with control_socket set in the config.
+`
+
+// runCtl sends a command to the control socket of the running agent.
+func runCtl(args []string) int {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	collector := flags.String("collector", "", "name of the collector to pause, resume or trigger (default: all collectors)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), ctlUsage, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := oslog_collector.LoadConfigFromFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return 2
+	}
+
+	if config.ControlSocket == "" {
+		fmt.Fprintln(os.Stderr, "control_socket is not set in the config")
+		return 2
+	}
+
+	response, err := oslog_collector.SendControlCommand(config.ControlSocket, oslog_collector.ControlRequest{
+		Command:   flags.Arg(1),
+		Collector: *collector,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if !response.OK {
+		fmt.Fprintln(os.Stderr, response.Error)
+		return 1
+	}
+
+	if response.Status != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(response.Status); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Println(response.Message)
+	return 0
+}
@@ -3,25 +3,82 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 
 	oslog_collector "github.com/mrtc0/oslog-collector"
 )
 
+const usage = `Usage:
+  %[1]s <config_file>          Run the agent
+  %[1]s check [-dump] <config_file>
+                               Validate the config and print the log command of each collector
+  %[1]s once <config_file>     Run a single collection of the collectors and exit
+  %[1]s backfill <config_file> Collect the logs of a collector in an explicit time range
+  %[1]s position <action> <config_file>
+                               Show, set, rewind or reset the positions of the collectors
+  %[1]s ctl <config_file> <command>
+                               Send a command to the control socket of the running agent
+`
+
+// subcommands maps the name of a subcommand to the function that runs it and returns the exit code.
+var subcommands = map[string]func(args []string) int{
+	"check":    runCheck,
+	"once":     runOnce,
+	"backfill": runBackfill,
+	"position": runPosition,
+	"ctl":      runCtl,
+}
+
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatalf("Usage: ./%s <config_file>\n", os.Args[0])
+		printUsage()
+		os.Exit(2)
 	}
 
-	configFile := os.Args[1]
+	if subcommand, ok := subcommands[os.Args[1]]; ok {
+		os.Exit(subcommand(os.Args[2:]))
+	}
 
-	agent, err := oslog_collector.NewAgentFromConfigFile(configFile)
+	switch os.Args[1] {
+	case "-h", "-help", "--help", "help":
+		printUsage()
+		return
+	}
+
+	os.Exit(runAgent(os.Args[1]))
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, usage, os.Args[0])
+}
+
+func runAgent(configFile string) int {
+	config, err := oslog_collector.LoadConfigFromFile(configFile)
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+		return 1
+	}
+
+	logger, logFile, err := oslog_collector.NewLogger(config.Log)
 	if err != nil {
-		log.Fatalf("failed to create oslog-collector agent: %v", err)
+		slog.Error("Failed to create logger", "error", err)
+		return 1
+	}
+	defer logFile.Close()
+	slog.SetDefault(logger)
+
+	agent, err := oslog_collector.NewAgent(config, oslog_collector.WithConfigFilePath(configFile), oslog_collector.WithLogFile(logFile))
+	if err != nil {
+		slog.Error("Failed to create oslog-collector agent", "error", err)
+		return 1
 	}
 
 	if err := agent.Run(); err != nil {
-		log.Fatalf("failed to run oslog-collector agent: %v", err)
+		slog.Error("Failed to run oslog-collector agent", "error", err)
+		return 1
 	}
+
+	return 0
 }
@@ -0,0 +1,64 @@
+//go:build darwin
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+)
+
+// runOnce runs a single collection of all or the selected collectors and exits.
+// It exits with 1 if any collector failed.
+func runOnce(args []string) int {
+	flags := flag.NewFlagSet("once", flag.ExitOnError)
+	collectors := flags.String("collector", "", "comma-separated names of the collectors to run (default: all collectors)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s once [-collector <name>[,<name>...]] <config_file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := oslog_collector.LoadConfigFromFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return 2
+	}
+
+	var names []string
+	if *collectors != "" {
+		names = strings.Split(*collectors, ",")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results, err := oslog_collector.RunOnce(ctx, config, names)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	exitCode := 0
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("%s: failed: %v\n", result.Name, result.Err)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("%s: ok\n", result.Name)
+	}
+
+	return exitCode
+}
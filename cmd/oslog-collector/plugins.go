@@ -0,0 +1,14 @@
+//go:build darwin
+
+package main
+
+// Third-party outputs and processors are compiled into a custom binary by importing their packages here for the side
+// effect of registering them with oslog_collector.RegisterOutput and oslog_collector.RegisterProcessor in their init
+// functions, e.g.
+//
+//	import (
+//		_ "example.com/oslog-collector-kafka"
+//	)
+//
+// and then building the binary with `go build ./cmd/oslog-collector`. The registered types can be used in outputs
+// and processors of collectors.
@@ -0,0 +1,114 @@
+//go:build darwin
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+)
+
+const positionUsage = `Usage: %[1]s position <action> [-collector <name>[,<name>...]] <config_file> [<argument>]
+
+Actions:
+  show                     Show the positions
+  set <time>               Set the positions to the time, e.g. "2025-01-29 00:00:00" or "-6h"
+  rewind <duration>        Move the positions back by the duration, e.g. "30m" or "2d"
+  reset                    Remove the position files, so that the collectors start from now
+
+The positions cannot be modified while the agent is running.
+`
+
+// runPosition shows or modifies the position files of the collectors in the config.
+func runPosition(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, positionUsage, os.Args[0])
+		return 2
+	}
+
+	action := args[0]
+
+	flags := flag.NewFlagSet("position "+action, flag.ExitOnError)
+	collectors := flags.String("collector", "", "comma-separated names of the collectors (default: all collectors)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), positionUsage, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+
+	expectArgs := 2
+	if action == "show" || action == "reset" {
+		expectArgs = 1
+	}
+	if flags.NArg() != expectArgs {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := oslog_collector.LoadConfigFromFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return 2
+	}
+
+	var names []string
+	if *collectors != "" {
+		names = strings.Split(*collectors, ",")
+	}
+
+	var changes []oslog_collector.PositionChange
+
+	switch action {
+	case "show":
+		changes, err = oslog_collector.ShowPositions(config, names)
+	case "set":
+		var t time.Time
+		if t, err = oslog_collector.ParseTime(flags.Arg(1), flextime.Now()); err != nil {
+			break
+		}
+		changes, err = oslog_collector.SetPositions(config, names, t)
+	case "rewind":
+		var d time.Duration
+		if d, err = oslog_collector.ParseDuration(flags.Arg(1)); err != nil {
+			break
+		}
+		changes, err = oslog_collector.RewindPositions(config, names, d)
+	case "reset":
+		changes, err = oslog_collector.ResetPositions(config, names)
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	for _, change := range changes {
+		if action == "show" {
+			fmt.Printf("%s: %s (%s)\n", change.Name, positionOrNone(change.Before), change.PositionFile)
+			continue
+		}
+		fmt.Printf("%s: %s -> %s (%s)\n", change.Name, positionOrNone(change.Before), positionOrNone(change.After), change.PositionFile)
+	}
+
+	if err != nil {
+		if len(changes) > 0 {
+			// the changes printed above were written before the error
+			fmt.Fprintf(os.Stderr, "failed to %s positions after changing the ones above: %v\n", action, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "failed to %s positions, no position is changed: %v\n", action, err)
+		return 1
+	}
+
+	return 0
+}
+
+func positionOrNone(position string) string {
+	if position == "" {
+		return "(none)"
+	}
+	return position
+}
@@ -2,10 +2,9 @@ package oslog_collector
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
 	"sync"
 	"time"
 
@@ -13,26 +12,85 @@ import (
 )
 
 var (
-	LogCommandTimeFormat = "2006-01-02 15:04:05"
+	// LogCommandTimeFormat is the format of --start and --end of the log command, which has the offset from UTC
+	// so that the times are not ambiguous when the timezone of the system changes or during DST transitions
+	LogCommandTimeFormat = "2006-01-02 15:04:05-0700"
 	defaultStyle         = "ndjson"
 )
 
-type Position struct {
-	LastTimestamp string `json:"last_timestamp"`
-}
+// ErrCommandTimeout is returned when the log command is killed because it ran longer than the command timeout.
+var ErrCommandTimeout = errors.New("log command timed out")
 
 type OSLogCollector struct {
-	Name          string
-	Predicate     string
-	OutputFile    string
-	PositionFile  string
-	Interval      int
-	LastTimestamp string
-	WithInfoLevel bool
+	Name         string
+	Predicate    string
+	OutputFile   string
+	PositionFile string
+	Interval     int
+	// MinInterval and MaxInterval are the range of the adaptive interval, which is disabled if MaxInterval is 0
+	MinInterval int
+	MaxInterval int
+	// Align runs the collector at the multiples of Interval on the wall clock
+	Align bool
+	// Schedule is the cron expression of the times to collect logs, which is used instead of Interval if set
+	Schedule string
+	// CommandTimeout is the seconds after which the log command is killed, or 0 for no timeout
+	CommandTimeout int
+	// CatchUpWindow is the maximum seconds of the windows collected after the wall clock jumped forward
+	CatchUpWindow int
+	// Priority orders the collectors waiting for a log command slot, higher first
+	Priority int
+	// Splay is the maximum random offset of the first collection
+	Splay time.Duration
+	// IntervalJitter randomizes each interval by the fraction of itself
+	IntervalJitter float64
+	LastTimestamp  string
+	WithInfoLevel  bool
+
+	WithDebugLevel     bool
+	WithSignpost       bool
+	Process            string
+	WithSource         bool
+	Timezone           string
+	NoBacktrace        bool
+	MachContinuousTime bool
+	Last               string
+	Archive            string
+	Color              string
+	// Retry is the policy to retry a failed collection within a cycle
+	Retry RetryConfig
+	// CircuitBreaker slows down the collector after consecutive failed cycles, which is disabled if FailureThreshold is 0
+	CircuitBreaker CircuitBreakerConfig
 
 	logCommandRunnerGenerator LogCommandRunnerGenerator
-	logFile                   *os.File
+	logFile                   outputFile
+	positionLock              *fileLock
+	metrics                   *Metrics
+	commandLimiter            *CommandLimiter
+	pipeline                  pipeline
+	schedule                  Schedule
+	logger                    *slog.Logger
 	mu                        sync.Mutex
+	triggerCh                 chan struct{}
+	monotonicNow              func() time.Duration
+	// lastClockReading is the clocks read at the start of the previous cycle to detect clock jumps
+	lastClockReading clockReading
+	// cycle is the output of the log commands run in the current cycle
+	cycle cycleStats
+	// windowLimit is the maximum length of a window after the log command timed out, or 0 for no limit
+	windowLimit time.Duration
+	// failedWindowEnd is the end of the window that failed in the previous attempt, which is collected again with
+	// the same end before the window up to now, so that the outputs and the sink that received it are skipped
+	failedWindowEnd string
+
+	// statusMu guards LastTimestamp, result, paused, consecutiveFailures, degraded and currentInterval,
+	// which are read and written from other goroutines
+	statusMu            sync.Mutex
+	result              collectorResult
+	paused              bool
+	consecutiveFailures int
+	degraded            bool
+	currentInterval     time.Duration
 }
 
 type OSLogCollectorOption func(*OSLogCollector)
@@ -43,30 +101,130 @@ func WithLogCommandRunner(generator LogCommandRunnerGenerator) OSLogCollectorOpt
 	}
 }
 
+// WithMetrics records the collections of the collector to the metrics.
+func WithMetrics(metrics *Metrics) OSLogCollectorOption {
+	return func(c *OSLogCollector) {
+		c.metrics = metrics
+	}
+}
+
+// WithCommandLimiter limits the log commands of the collector together with the others sharing the limiter.
+func WithCommandLimiter(limiter *CommandLimiter) OSLogCollectorOption {
+	return func(c *OSLogCollector) {
+		c.commandLimiter = limiter
+	}
+}
+
+// withPositionLock hands over the lock of the position file held by a collector being replaced on reload,
+// which is kept held by the replaced collector if the new one fails to be created.
+func withPositionLock(lock *fileLock) OSLogCollectorOption {
+	return func(c *OSLogCollector) {
+		c.positionLock = lock
+	}
+}
+
 func NewOSLogCollector(config OSLogCollectorConfig, opts ...OSLogCollectorOption) (*OSLogCollector, error) {
+	collector := newOSLogCollector(config, opts...)
+	if collector.OutputFile == "" && collector.pipeline.sink == nil && len(config.Outputs) == 0 {
+		return nil, errUnsetOutput
+	}
+	// the config of an embedding program is not validated by validateConfig
+	if err := validatePositionFile(collector.PositionFile); err != nil {
+		return nil, err
+	}
+
+	schedule, err := newSchedule(config)
+	if err != nil {
+		return nil, err
+	}
+	collector.schedule = schedule
+
+	handedOver := collector.positionLock != nil
+	if !handedOver {
+		lock, err := lockStateFile(collector.PositionFile)
+		if err != nil {
+			return nil, err
+		}
+		collector.positionLock = lock
+	}
+
+	fail := func(err error) (*OSLogCollector, error) {
+		if handedOver {
+			collector.positionLock = nil
+		}
+		collector.Close()
+		return nil, err
+	}
+
+	if err := collector.loadPosition(); err != nil {
+		return fail(err)
+	}
+	if err := collector.migratePosition(); err != nil {
+		return fail(err)
+	}
+	collector.metrics.setPosition(collector.Name, collector.LastTimestamp)
+
+	if err := collector.OpenLogFile(); err != nil {
+		return fail(err)
+	}
+
+	if err := collector.openPlugins(config); err != nil {
+		return fail(err)
+	}
+
+	return collector, nil
+}
+
+// newOSLogCollector creates a collector without touching the position file and the output file.
+func newOSLogCollector(config OSLogCollectorConfig, opts ...OSLogCollectorOption) *OSLogCollector {
+	logger := slog.Default().With("collector_name", config.Name)
 	collector := &OSLogCollector{
 		Name:                      config.Name,
 		Predicate:                 config.Predicate,
 		OutputFile:                config.OutputFile,
 		PositionFile:              config.PositionFile,
 		Interval:                  config.Interval,
+		MinInterval:               config.MinInterval,
+		MaxInterval:               config.MaxInterval,
+		Align:                     config.Align,
+		Schedule:                  config.Schedule,
+		CommandTimeout:            config.CommandTimeout,
+		CatchUpWindow:             config.CatchUpWindow,
+		Priority:                  config.Priority,
+		Splay:                     splay(config),
+		IntervalJitter:            config.IntervalJitter,
 		WithInfoLevel:             config.WithInfoLevel,
-		logCommandRunnerGenerator: NewLogCommandRunner,
+		WithDebugLevel:            config.WithDebugLevel,
+		WithSignpost:              config.WithSignpost,
+		Process:                   config.Process,
+		WithSource:                config.WithSource,
+		Timezone:                  config.Timezone,
+		NoBacktrace:               config.NoBacktrace,
+		MachContinuousTime:        config.MachContinuousTime,
+		Last:                      config.Last,
+		Archive:                   config.Archive,
+		Color:                     config.Color,
+		logCommandRunnerGenerator: newLogCommandRunner(logger),
+		triggerCh:                 make(chan struct{}, 1),
+		monotonicNow:              monotonicNow,
+		currentInterval:           time.Duration(config.Interval) * time.Second,
+		result:                    collectorResult{firstDue: flextime.Now()},
+		pipeline:                  pipeline{name: config.Name},
+		logger:                    logger,
 	}
 
-	for _, opt := range opts {
-		opt(collector)
+	if config.Retry != nil {
+		collector.Retry = *config.Retry
 	}
-
-	if err := collector.loadPosition(); err != nil {
-		return nil, err
+	if config.CircuitBreaker != nil {
+		collector.CircuitBreaker = *config.CircuitBreaker
 	}
 
-	if err := collector.OpenLogFile(); err != nil {
-		return nil, err
+	for _, opt := range opts {
+		opt(collector)
 	}
 
-	return collector, nil
+	return collector
 }
 
 // StartLogCollectors starts the OS Log collectors in the background.
@@ -78,103 +236,376 @@ func StartLogCollectors(ctx context.Context, collectors []*OSLogCollector) {
 
 		go func(c *OSLogCollector) {
 			defer wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
+			runLogCollector(ctx, c)
+		}(collector)
+	}
+
+	wg.Wait()
+}
+
+// runLogCollector collects logs at startup, or at the first time of the schedule if the collector is scheduled,
+// after the random offset up to Splay, and then every interval or at the times of the schedule, or every probe interval
+// while the collector is degraded, until the context is canceled.
+// Scheduled collections are skipped while the collector is paused, but triggered ones are not.
+func runLogCollector(ctx context.Context, c *OSLogCollector) {
+	delay := c.initialDelay()
+	c.setFirstDue(flextime.Now().Add(delay))
+	timer := flextime.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		var collect bool
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			collect = !c.Paused()
+		case <-c.triggerCh:
+			collect = true
+		}
+
+		if collect {
+			if err := c.collectWithRetry(ctx); err != nil {
+				if ctx.Err() != nil {
+					c.logger.Warn("Collection is interrupted, the window is collected again on the next start", "error", err)
 					return
-				default:
-					if err := c.CollectLogs(); err != nil {
-						slog.Error("Error collecting logs", "collector_name", c.Name, "error", err)
-					}
-					time.Sleep(time.Duration(c.Interval) * time.Second)
 				}
+				c.logger.Error("Error collecting logs", "error_class", errorClass(err), "error", err)
 			}
-		}(collector)
+		}
+
+		timer.Reset(c.nextInterval())
 	}
+}
 
-	wg.Wait()
+func (c *OSLogCollector) taskKey() string {
+	return collectorTaskKey(c.Name)
+}
+
+func (c *OSLogCollector) run(ctx context.Context) {
+	runLogCollector(ctx, c)
+}
+
+func (c *OSLogCollector) taskLogger() *slog.Logger {
+	return c.logger
 }
 
-func (c *OSLogCollector) CollectLogs() error {
-	endTime := flextime.Now().Format(LogCommandTimeFormat)
+func (c *OSLogCollector) lockedFile() string {
+	return c.PositionFile
+}
+
+func (c *OSLogCollector) heldLock() *fileLock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.positionLock
+}
+
+func (c *OSLogCollector) handOverLock() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.positionLock = nil
+}
+
+// errorClass classifies the error of a collection for logs.
+func errorClass(err error) string {
+	if errors.Is(err, ErrCommandTimeout) {
+		return "timeout"
+	}
+	return "error"
+}
+
+// Pause stops the scheduled collections of the collector until Resume is called.
+func (c *OSLogCollector) Pause() {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.paused = true
+}
+
+func (c *OSLogCollector) Resume() {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.paused = false
+}
 
-	command := NewLogCommandBuilder().
-		WithPredicate(c.Predicate).WithStartTime(c.LastTimestamp).WithEndTime(endTime).
-		WithStyle(defaultStyle).WithInfoLevel(c.WithInfoLevel).
-		Build()
-	output, err := c.logCommandRunnerGenerator(command).RunLogCommand()
+func (c *OSLogCollector) Paused() bool {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	return c.paused
+}
+
+// Trigger requests a collection without waiting for the interval. It does nothing if a request is already pending.
+func (c *OSLogCollector) Trigger() {
+	select {
+	case c.triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+// CollectLogs collects the logs from the position to now and saves the position.
+// If the context is done, the log command is terminated and the position is not saved.
+// The adaptive interval is updated from the output of a successful collection.
+func (c *OSLogCollector) CollectLogs(ctx context.Context) error {
+	c.cycle = cycleStats{}
+	c.detectClockJump()
+	err := c.collectLogs(ctx)
+	c.metrics.observeRun(c.Name, err)
+	c.recordResult(err)
+	if err == nil {
+		c.adaptInterval(c.cycle)
+	}
+	return err
+}
+
+// collectLogs collects the logs up to now. If the log command times out, the window is split in half and retried,
+// and the following windows are limited to the half until the collector catches up with now.
+// A window that failed in the previous attempt is collected again from the same start to the same end first.
+// Nothing is collected if the window is negative or zero-length.
+func (c *OSLogCollector) collectLogs(ctx context.Context) error {
+	endTime := flextime.Now().Format(PositionTimeFormat)
+	if c.emptyWindow(endTime) {
+		// a new collector saves the position it starts from even if the first window is empty,
+		// so that the logs since then are collected after a restart
+		if pos, err := ReadPosition(c.PositionFile); err == nil && pos == nil {
+			return c.savePosition()
+		}
+		return nil
+	}
+
+	for {
+		windowEnd := c.windowEnd(endTime)
+		if c.failedWindowEnd != "" {
+			windowEnd = c.failedWindowEnd
+		}
+
+		err := c.collectWindow(ctx, c.LastTimestamp, windowEnd)
+		if errors.Is(err, ErrCommandTimeout) && c.splitWindow(windowEnd) {
+			c.logger.Warn("Log command timed out, retrying the window split in half",
+				"start", c.LastTimestamp, "end", windowEnd, "window_limit", c.windowLimit)
+			c.failedWindowEnd = ""
+			continue
+		} else if err != nil {
+			c.failedWindowEnd = windowEnd
+			return err
+		}
+		c.failedWindowEnd = ""
+
+		c.setLastTimestamp(windowEnd)
+		c.metrics.setPosition(c.Name, c.LastTimestamp)
+		if err := c.savePosition(); err != nil {
+			return err
+		}
+
+		// the failed window may end after now if the wall clock jumped backward
+		if c.emptyWindow(endTime) {
+			c.windowLimit = 0
+			return nil
+		}
+	}
+}
+
+// windowEnd returns the end of the next window, which is limited to windowLimit from the position after a timeout.
+func (c *OSLogCollector) windowEnd(endTime string) string {
+	if c.windowLimit == 0 {
+		return endTime
+	}
+
+	start, err := parseLogTimestamp(c.LastTimestamp)
+	if err != nil {
+		return endTime
+	}
+	end, err := parseLogTimestamp(endTime)
+	if err != nil {
+		return endTime
+	}
+
+	if limited := start.Add(c.windowLimit); limited.Before(end) {
+		return limited.In(end.Location()).Format(PositionTimeFormat)
+	}
+	return endTime
+}
+
+// splitWindow limits the windows to the half of the window that timed out.
+// It returns false if the window cannot be split, e.g. it starts from --last or is shorter than 2 seconds.
+func (c *OSLogCollector) splitWindow(windowEnd string) bool {
+	start, err := parseLogTimestamp(c.LastTimestamp)
+	if err != nil {
+		return false
+	}
+	end, err := parseLogTimestamp(windowEnd)
+	if err != nil {
+		return false
+	}
+
+	half := (end.Sub(start) / 2).Truncate(time.Second)
+	if half < time.Second {
+		return false
+	}
+
+	c.windowLimit = half
+	return true
+}
+
+// collectWindow writes the logs from startTime to endTime to the output file without updating the position.
+func (c *OSLogCollector) collectWindow(ctx context.Context, startTime, endTime string) error {
+	builder := c.newLogCommandBuilder(startTime, endTime)
+	if err := builder.Validate(); err != nil {
+		return fmt.Errorf("invalid log command: %v", err)
+	}
+
+	release, err := c.commandLimiter.acquire(ctx, c.Priority)
+	if err != nil {
+		return fmt.Errorf("error waiting for a log command slot: %w", err)
+	}
+
+	runCtx := ctx
+	if c.CommandTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, time.Duration(c.CommandTimeout)*time.Second)
+		defer cancel()
+	}
+
+	command := builder.Build()
+	started := time.Now()
+	output, err := c.logCommandRunnerGenerator(command).RunLogCommand(runCtx)
+	release()
+	duration := time.Since(started)
+	c.metrics.observeLogCommand(c.Name, duration)
 	if err != nil {
+		if ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			c.metrics.observeTimeout(c.Name)
+			return fmt.Errorf("%w after %d seconds: %v", ErrCommandTimeout, c.CommandTimeout, err)
+		}
 		return fmt.Errorf("error executing log command: %v, output: %s", err, string(output))
 	}
 
-	if err := c.writeToLogFile(output); err != nil {
+	// the output file is written after the sink acknowledges the window, so that a window collected again after
+	// a failed delivery is not written twice
+	if err := c.pipeline.deliver(ctx, startTime+" "+endTime, startTime, endTime, output); err != nil {
 		return err
 	}
 
-	c.LastTimestamp = endTime
-	return c.savePosition()
-}
+	entries := countLogEntries(output)
+	if c.OutputFile != "" {
+		err := c.logFile.write(output)
+		c.metrics.observeOutput(c.Name, len(output), entries, err)
+		if err != nil {
+			return err
+		}
+	}
+	c.pipeline.commit()
 
-func (c *OSLogCollector) OpenLogFile() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.cycle.entries += entries
+	c.cycle.commandDuration += duration
+
+	c.logger.Debug("Collected logs", "start", startTime, "end", endTime, "duration", duration,
+		"bytes", len(output), "entries", entries)
+	return nil
+}
 
-	if c.logFile != nil {
-		c.logFile.Close()
+// newLogCommandBuilder returns a builder with all options of the collector for the window from startTime to endTime,
+// which are formatted in PositionTimeFormat and converted to LogCommandTimeFormat for the log command.
+// If startTime is empty, the position file does not exist yet and the window starts from the --last option.
+func (c *OSLogCollector) newLogCommandBuilder(startTime, endTime string) *logCommandBuilder {
+	builder := NewLogCommandBuilder().WithPredicate(c.Predicate)
+	if startTime == "" {
+		builder.WithLast(c.Last)
+	} else {
+		builder.WithStartTime(logCommandTime(startTime))
 	}
 
-	file, err := os.OpenFile(c.OutputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return builder.WithEndTime(logCommandTime(endTime)).
+		WithStyle(defaultStyle).WithInfoLevel(c.WithInfoLevel).WithDebugLevel(c.WithDebugLevel).
+		WithSignpost(c.WithSignpost).WithProcess(c.Process).WithSource(c.WithSource).
+		WithTimezone(c.Timezone).WithNoBacktrace(c.NoBacktrace).WithMachContinuousTime(c.MachContinuousTime).
+		WithArchive(c.Archive).WithColor(c.Color)
+}
+
+// OpenLogFile opens the output file, or does nothing if the collector has no output file but outputs or a sink.
+func (c *OSLogCollector) OpenLogFile() error {
+	return c.logFile.open(c.OutputFile)
+}
+
+// openPlugins creates the outputs and the processors of the collector.
+func (c *OSLogCollector) openPlugins(config OSLogCollectorConfig) error {
+	outs, procs, err := newPlugins(config.Outputs, config.Processors)
 	if err != nil {
-		return fmt.Errorf("error opening file: %v", err)
+		return err
 	}
 
-	c.logFile = file
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pipeline.outputs = outs
+	c.pipeline.processors = procs
 	return nil
 }
 
-func (c *OSLogCollector) writeToLogFile(data []byte) error {
+// Close closes the output file and the outputs of the collector and releases the lock of the position file.
+func (c *OSLogCollector) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.logFile == nil {
-		return fmt.Errorf("file is not open")
-	}
+	errs := []error{c.logFile.close(), closeOutputs(c.pipeline.outputs)}
+	c.pipeline.outputs = nil
 
-	_, err := c.logFile.Write(data)
-	if err != nil {
-		return fmt.Errorf("error writing to file: %v", err)
+	if c.positionLock != nil {
+		errs = append(errs, c.positionLock.Unlock())
+		c.positionLock = nil
 	}
-	return nil
+
+	return errors.Join(errs...)
+}
+
+// Flush commits the output file to the disk.
+func (c *OSLogCollector) Flush() error {
+	return c.logFile.sync()
 }
 
 func (c *OSLogCollector) loadPosition() error {
-	data, err := os.ReadFile(c.PositionFile)
-	if os.IsNotExist(err) {
-		c.LastTimestamp = flextime.Now().Format(LogCommandTimeFormat)
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("error reading position file: %v", err)
+	pos, err := ReadPosition(c.PositionFile)
+	if err != nil {
+		return err
 	}
 
-	var pos Position
-	if err := json.Unmarshal(data, &pos); err != nil {
-		return fmt.Errorf("error parsing position file: %v", err)
+	if pos == nil {
+		if c.Last != "" {
+			c.LastTimestamp = ""
+			return nil
+		}
+		c.LastTimestamp = flextime.Now().Format(PositionTimeFormat)
+		return nil
 	}
 
 	c.LastTimestamp = pos.LastTimestamp
 	return nil
 }
 
-func (c *OSLogCollector) savePosition() error {
-	pos := Position{LastTimestamp: c.LastTimestamp}
-	data, err := json.Marshal(pos)
-	if err != nil {
-		return fmt.Errorf("error marshaling position: %v", err)
+// migratePosition rewrites the position saved without the offset by an older version in PositionTimeFormat,
+// interpreting it in the local timezone. The caller must hold the lock of the position file.
+func (c *OSLogCollector) migratePosition() error {
+	if c.LastTimestamp == "" {
+		return nil
+	}
+	if _, err := time.Parse(PositionTimeFormat, c.LastTimestamp); err == nil {
+		return nil
 	}
 
-	if err := os.WriteFile(c.PositionFile, data, 0644); err != nil {
-		return fmt.Errorf("error writing position file: %v", err)
+	t, err := parseLogTimestamp(c.LastTimestamp)
+	if err != nil {
+		return fmt.Errorf("invalid position %q: %v", c.LastTimestamp, err)
 	}
 
-	return nil
+	migrated := t.Format(PositionTimeFormat)
+	c.logger.Info("Migrating position to RFC 3339", "position", c.LastTimestamp, "new_position", migrated)
+	c.LastTimestamp = migrated
+	return c.savePosition()
+}
+
+func (c *OSLogCollector) savePosition() error {
+	return WritePosition(c.PositionFile, Position{LastTimestamp: c.LastTimestamp})
 }
@@ -1,25 +1,21 @@
 package oslog_collector_test
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/Songmu/flextime"
 	oslog_collector "github.com/mrtc0/oslog-collector"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-type mockLogCommandRunner struct {
-}
-
-func (m *mockLogCommandRunner) RunLogCommand() ([]byte, error) {
-	dummyLog := []byte("test log ")
-	return dummyLog, nil
-}
-
 func TestOSLogCollector_CollectLog(t *testing.T) {
 	type expect struct {
 		logs string
@@ -36,19 +32,19 @@ func TestOSLogCollector_CollectLog(t *testing.T) {
 	}{
 		"when interval with 60 seconds": {
 			arrange: arrange{
-				nowTime: time.Date(2025, 1, 29, 0, 0, 0, 0, time.Now().Location()),
+				nowTime: time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC),
 			},
 			expect: expect{
-				logs: "test log test log test log ", // "test log " * 3
+				logs: "test log test log ", // "test log " * 2
 			},
 			interval: 60 * time.Second,
 		},
 		"when interval with 30 seconds": {
 			arrange: arrange{
-				nowTime: time.Date(2025, 1, 29, 0, 0, 0, 0, time.Now().Location()),
+				nowTime: time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC),
 			},
 			expect: expect{
-				logs: "test log test log test log ", // "test log " * 3
+				logs: "test log test log ", // "test log " * 2
 			},
 			interval: 30 * time.Second,
 		},
@@ -79,18 +75,14 @@ func TestOSLogCollector_CollectLog(t *testing.T) {
 				var startTime string
 				var endTime string
 
-				if count == 1 {
-					// If the pos file does not exist at the first startup, the start time will be the current time.
-					startTime = tt.nowTime.Format(oslog_collector.LogCommandTimeFormat)
-					endTime = flextime.Now().Format(oslog_collector.LogCommandTimeFormat)
-				} else {
-					// From the second time onwards, the end time of the previous time becomes the start time.
-					startTime = flextime.Now().Add(-tt.interval).Format(oslog_collector.LogCommandTimeFormat)
-					endTime = flextime.Now().Format(oslog_collector.LogCommandTimeFormat)
-				}
+				// If the pos file does not exist at the first startup, the start time is the current time and the empty
+				// window is skipped. From the second time onwards, the end time of the previous time becomes the start time.
+				assert.Greater(t, count, 1, "the empty first window is not collected")
+				startTime = flextime.Now().Add(-tt.interval).Format(oslog_collector.LogCommandTimeFormat)
+				endTime = flextime.Now().Format(oslog_collector.LogCommandTimeFormat)
 
 				assert.Equal(t, []string{"log", "show", "--predicate", "eventMessage contains[cd] \"test\"", "--start", startTime, "--end", endTime, "--style", "ndjson"}, args)
-				return &mockLogCommandRunner{}
+				return testLogRunner
 			}
 
 			collector, err := oslog_collector.NewOSLogCollector(
@@ -103,12 +95,12 @@ func TestOSLogCollector_CollectLog(t *testing.T) {
 				count++
 
 				t.Run(fmt.Sprintf("collect %d", i), func(t *testing.T) {
-					err = collector.CollectLogs()
+					err = collector.CollectLogs(context.Background())
 					assert.NoError(t, err)
 
 					pos, err := os.ReadFile(cfg.PositionFile)
 					assert.NoError(t, err)
-					assert.Equal(t, fmt.Sprintf("{\"last_timestamp\":\"%s\"}", flextime.Now().Format(oslog_collector.LogCommandTimeFormat)), string(pos))
+					assert.Equal(t, fmt.Sprintf("{\"last_timestamp\":\"%s\"}", flextime.Now().Format(oslog_collector.PositionTimeFormat)), string(pos))
 				})
 
 				// emulate the sleep
@@ -123,3 +115,196 @@ func TestOSLogCollector_CollectLog(t *testing.T) {
 
 	flextime.Restore()
 }
+
+func TestOSLogCollector_CollectLog_WithLast(t *testing.T) {
+	nowTime := time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC)
+	flextime.Set(nowTime)
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	filename := filepath.Join(workdir, fmt.Sprintf("%d", time.Now().UnixNano()))
+
+	cfg := oslog_collector.OSLogCollectorConfig{
+		Name:         "test",
+		Predicate:    "eventMessage contains[cd] \"test\"",
+		OutputFile:   filename + ".log",
+		PositionFile: filename + ".pos",
+		Interval:     60,
+		Last:         "1h",
+		Timezone:     "UTC",
+	}
+
+	var commands [][]string
+	dummyRunnerGeenerator := func(args []string) oslog_collector.LogCommandRunner {
+		commands = append(commands, args)
+		return testLogRunner
+	}
+
+	collector, err := oslog_collector.NewOSLogCollector(cfg, oslog_collector.WithLogCommandRunner(dummyRunnerGeenerator))
+	assert.NoError(t, err)
+
+	assert.NoError(t, collector.CollectLogs(context.Background()))
+	flextime.Set(nowTime.Add(time.Minute))
+	assert.NoError(t, collector.CollectLogs(context.Background()))
+
+	// the first collection uses --last because the position file does not exist
+	assert.Equal(t, []string{"log", "show", "--predicate", "eventMessage contains[cd] \"test\"", "--last", "1h", "--end", "2025-01-29 00:00:00+0000", "--style", "ndjson", "--timezone", "UTC"}, commands[0])
+	// the following collections start from the position
+	assert.Equal(t, []string{"log", "show", "--predicate", "eventMessage contains[cd] \"test\"", "--start", "2025-01-29 00:00:00+0000", "--end", "2025-01-29 00:01:00+0000", "--style", "ndjson", "--timezone", "UTC"}, commands[1])
+}
+
+// slowRunner returns a runner that does not finish until the context is done if the window is longer than maxWindow.
+func slowRunner(args []string, maxWindow time.Duration) runnerFunc {
+	return func(ctx context.Context) ([]byte, error) {
+		start, _ := time.Parse(oslog_collector.LogCommandTimeFormat, args[5])
+		end, _ := time.Parse(oslog_collector.LogCommandTimeFormat, args[7])
+		if end.Sub(start) > maxWindow {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []byte(args[5] + " - " + args[7] + "\n"), nil
+	}
+}
+
+func TestOSLogCollector_CollectLog_CommandTimeout(t *testing.T) {
+	nowTime := time.Date(2025, 1, 29, 1, 0, 0, 0, time.UTC)
+	flextime.Fix(nowTime)
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	cfg := oslog_collector.OSLogCollectorConfig{
+		Name:           "test",
+		Predicate:      "process == 'test'",
+		OutputFile:     filepath.Join(workdir, "test.log"),
+		PositionFile:   filepath.Join(workdir, "test.pos"),
+		Interval:       60,
+		CommandTimeout: 1,
+	}
+	require.NoError(t, oslog_collector.WritePosition(cfg.PositionFile, oslog_collector.Position{LastTimestamp: "2025-01-29T00:00:00Z"}))
+
+	metrics := oslog_collector.NewMetrics()
+	collector, err := oslog_collector.NewOSLogCollector(cfg,
+		oslog_collector.WithMetrics(metrics),
+		oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+			return slowRunner(args, 15*time.Minute)
+		}))
+	require.NoError(t, err)
+	defer collector.Close()
+
+	// the window of 1 hour and 30 minutes time out, and the windows of 15 minutes succeed
+	require.NoError(t, collector.CollectLogs(context.Background()))
+
+	logs, err := os.ReadFile(cfg.OutputFile)
+	require.NoError(t, err)
+	assert.Equal(t, `2025-01-29 00:00:00+0000 - 2025-01-29 00:15:00+0000
+2025-01-29 00:15:00+0000 - 2025-01-29 00:30:00+0000
+2025-01-29 00:30:00+0000 - 2025-01-29 00:45:00+0000
+2025-01-29 00:45:00+0000 - 2025-01-29 01:00:00+0000
+`, string(logs))
+
+	pos, err := oslog_collector.ReadPosition(cfg.PositionFile)
+	require.NoError(t, err)
+	assert.Equal(t, "2025-01-29T01:00:00Z", pos.LastTimestamp)
+
+	var buf strings.Builder
+	_, err = metrics.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), `oslog_collector_command_timeouts_total{collector="test"} 2`+"\n")
+
+	t.Run("when window cannot be split then return timeout error", func(t *testing.T) {
+		collector.Close()
+		collector, err := oslog_collector.NewOSLogCollector(cfg, oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+			return slowRunner(args, 0)
+		}))
+		require.NoError(t, err)
+		defer collector.Close()
+
+		flextime.Fix(nowTime.Add(time.Second))
+		err = collector.CollectLogs(context.Background())
+		assert.ErrorIs(t, err, oslog_collector.ErrCommandTimeout)
+	})
+}
+
+func TestOSLogCollector_CollectLog_DST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	testCases := map[string]struct {
+		position       string
+		now            time.Time
+		expectWindow   [2]string
+		expectPosition string
+	}{
+		"when clock falls back then the repeated hour is not ambiguous": {
+			position:       "2025-11-02T01:30:00-04:00",
+			now:            time.Date(2025, 11, 2, 1, 30, 0, 0, newYork).Add(time.Hour),
+			expectWindow:   [2]string{"2025-11-02 01:30:00-0400", "2025-11-02 01:30:00-0500"},
+			expectPosition: "2025-11-02T01:30:00-05:00",
+		},
+		"when clock springs forward then the skipped hour is not collected twice": {
+			position:       "2025-03-09T01:30:00-05:00",
+			now:            time.Date(2025, 3, 9, 3, 30, 0, 0, newYork),
+			expectWindow:   [2]string{"2025-03-09 01:30:00-0500", "2025-03-09 03:30:00-0400"},
+			expectPosition: "2025-03-09T03:30:00-04:00",
+		},
+	}
+
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			flextime.Fix(tt.now)
+			defer flextime.Restore()
+
+			workdir := t.TempDir()
+			cfg := oslog_collector.OSLogCollectorConfig{
+				Name:         "test",
+				Predicate:    "process == 'test'",
+				OutputFile:   filepath.Join(workdir, "test.log"),
+				PositionFile: filepath.Join(workdir, "test.pos"),
+				Interval:     60,
+			}
+			require.NoError(t, oslog_collector.WritePosition(cfg.PositionFile, oslog_collector.Position{LastTimestamp: tt.position}))
+
+			var windows [][2]string
+			collector, err := oslog_collector.NewOSLogCollector(cfg, oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+				windows = append(windows, [2]string{args[5], args[7]})
+				return testLogRunner
+			}))
+			require.NoError(t, err)
+			defer collector.Close()
+
+			require.NoError(t, collector.CollectLogs(context.Background()))
+			assert.Equal(t, [][2]string{tt.expectWindow}, windows)
+
+			pos, err := oslog_collector.ReadPosition(cfg.PositionFile)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectPosition, pos.LastTimestamp)
+		})
+	}
+}
+
+func TestNewOSLogCollector_MigratePosition(t *testing.T) {
+	workdir := t.TempDir()
+	cfg := oslog_collector.OSLogCollectorConfig{
+		Name:         "test",
+		Predicate:    "process == 'test'",
+		OutputFile:   filepath.Join(workdir, "test.log"),
+		PositionFile: filepath.Join(workdir, "test.pos"),
+		Interval:     60,
+	}
+
+	// the position saved by older versions has no offset, and is interpreted in the local timezone
+	require.NoError(t, oslog_collector.WritePosition(cfg.PositionFile, oslog_collector.Position{LastTimestamp: "2025-01-29 00:00:00"}))
+
+	collector, err := oslog_collector.NewOSLogCollector(cfg, oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		return testLogRunner
+	}))
+	require.NoError(t, err)
+	defer collector.Close()
+
+	expected := time.Date(2025, 1, 29, 0, 0, 0, 0, time.Local).Format(time.RFC3339)
+	assert.Equal(t, expected, collector.LastTimestamp)
+
+	pos, err := oslog_collector.ReadPosition(cfg.PositionFile)
+	require.NoError(t, err)
+	assert.Equal(t, expected, pos.LastTimestamp)
+}
@@ -1,17 +1,57 @@
 package oslog_collector
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 
+	"github.com/Songmu/flextime"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	// Collectors is a list of OS Log collectors
 	Collectors []OSLogCollectorConfig `yaml:"collectors"`
+	// ArchiveWatchers is a list of watchers that ingest .logarchive bundles dropped into a directory
+	ArchiveWatchers []ArchiveWatcherConfig `yaml:"archive_watchers"`
 	// PIDFile is the file to write the process ID to
 	PIDFile string `yaml:"pid_file"`
+	// Predicates is a map of named predicate fragments, which can be referenced as ${name} in predicates
+	Predicates map[string]string `yaml:"predicates,omitempty"`
+	// ShutdownTimeout is the seconds to wait for the collectors to stop on shutdown, after which the agent exits
+	// even if a collector is stuck. The default is 30.
+	ShutdownTimeout int `yaml:"shutdown_timeout,omitempty"`
+	// ControlSocket is the Unix domain socket accepting control commands, which is disabled if not set
+	ControlSocket string `yaml:"control_socket,omitempty"`
+	// Log is the logging of the agent itself, which logs at the info level in the text format to stderr if not set
+	Log *LogConfig `yaml:"log,omitempty"`
+	// HTTP is the HTTP listener serving the metrics, which is disabled if not set
+	HTTP *HTTPConfig `yaml:"http,omitempty"`
+	// MaxConcurrentCommands is the maximum number of log commands running at the same time, or 0 for no limit
+	MaxConcurrentCommands int `yaml:"max_concurrent_commands,omitempty"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn and error. The default is info.
+	Level string `yaml:"level"`
+	// Format is text or json. The default is text.
+	Format string `yaml:"format"`
+	// Output is stderr or the path of the log file. The default is stderr.
+	Output string `yaml:"output"`
+	// MaxSizeMB is the size in megabytes at which the log file is rotated, or 0 not to rotate it
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxBackups is the number of rotated log files to keep. The default is 5.
+	MaxBackups int `yaml:"max_backups"`
+}
+
+type HTTPConfig struct {
+	// Listen is the address to listen on, e.g. 127.0.0.1:9100
+	Listen string `yaml:"listen"`
+	// ReadyIntervals is the number of intervals within which every collector must have succeeded for /readyz to succeed.
+	// The default is 3.
+	ReadyIntervals int `yaml:"ready_intervals"`
 }
 
 type OSLogCollectorConfig struct {
@@ -19,6 +59,8 @@ type OSLogCollectorConfig struct {
 	Name string `yaml:"name"`
 	// Predicate is the condition to get logs, which is a string passed to the --predicate option of the log command
 	Predicate string `yaml:"predicate"`
+	// Match is a structured form of Predicate, which is compiled to Predicate when the config is loaded
+	Match *PredicateMatch `yaml:"match,omitempty"`
 	// OutputFile is the file to write the logs to
 	OutputFile string `yaml:"output_file"`
 	// PositionFile is the file to record the collection position of the logs
@@ -26,6 +68,101 @@ type OSLogCollectorConfig struct {
 	PositionFile string `yaml:"position_file"`
 	// Interval is the interval to collect logs in seconds
 	Interval int `yaml:"interval"`
+	// MinInterval and MaxInterval enable the adaptive interval, which starts from Interval, is shortened down to
+	// MinInterval while the collector collects many logs, and is lengthened up to MaxInterval while it collects nothing
+	MinInterval int `yaml:"min_interval,omitempty"`
+	MaxInterval int `yaml:"max_interval,omitempty"`
+	// Align runs the collector at the multiples of Interval since midnight on the wall clock instead of every Interval after the previous run
+	Align bool `yaml:"align,omitempty"`
+	// Schedule is the cron expression of the times to collect logs, e.g. "*/5 * * * *", which is exclusive with Interval
+	Schedule string `yaml:"schedule,omitempty"`
+	// CommandTimeout is the seconds after which a running log command is killed, or 0 for no timeout.
+	// A window that timed out is retried split in half.
+	CommandTimeout int `yaml:"command_timeout,omitempty"`
+	// CatchUpWindow is the maximum seconds of the windows collected after the wall clock jumped forward, e.g. after
+	// the Mac slept. The default is 3600.
+	CatchUpWindow int `yaml:"catch_up_window,omitempty"`
+	// Priority orders the collectors waiting for max_concurrent_commands, higher first. The default is 0.
+	Priority int `yaml:"priority,omitempty"`
+	// Splay is the maximum seconds of the random offset of the first collection, which spreads the collectors started together.
+	// The default is 10% of the interval, and 0 disables it.
+	Splay *int `yaml:"splay,omitempty"`
+	// IntervalJitter randomizes each interval by the fraction of itself, e.g. 0.1 for ±10%
+	IntervalJitter float64 `yaml:"interval_jitter,omitempty"`
+	// WithInfoLevel is a flag to enable the --info option of the log command
+	WithInfoLevel bool `yaml:"with_info_level"`
+	// WithDebugLevel is a flag to enable the --debug option of the log command
+	WithDebugLevel bool `yaml:"with_debug_level"`
+	// WithSignpost is a flag to enable the --signpost option of the log command
+	WithSignpost bool `yaml:"with_signpost"`
+	// Process is a process ID or name passed to the --process option of the log command
+	Process string `yaml:"process"`
+	// WithSource is a flag to enable the --source option of the log command
+	WithSource bool `yaml:"with_source"`
+	// Timezone is passed to the --timezone option of the log command
+	Timezone string `yaml:"timezone"`
+	// NoBacktrace is a flag to enable the --no-backtrace option of the log command
+	NoBacktrace bool `yaml:"no_backtrace"`
+	// MachContinuousTime is a flag to enable the --mach-continuous-time option of the log command
+	MachContinuousTime bool `yaml:"mach_continuous_time"`
+	// Last is passed to the --last option of the log command on the first collection when the position file does not exist.
+	// It is used to collect the logs of the past (e.g. 1h) at the first startup.
+	Last string `yaml:"last"`
+	// Archive is a .logarchive bundle passed to the --archive option of the log command instead of the live system logs
+	Archive string `yaml:"archive"`
+	// Color is passed to the --color option of the log command, which must be none
+	Color string `yaml:"color"`
+	// Retry is the policy to retry a failed collection within a cycle, which does not retry if not set
+	Retry *RetryConfig `yaml:"retry,omitempty"`
+	// CircuitBreaker slows down a collector that keeps failing, which is disabled if not set
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty"`
+	// Outputs are the registered outputs receiving the logs in addition to OutputFile, which is optional if Outputs are set
+	Outputs []PluginConfig `yaml:"outputs,omitempty"`
+	// Processors are the registered processors applied in order to the logs delivered to Outputs
+	Processors []PluginConfig `yaml:"processors,omitempty"`
+}
+
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts in a cycle including the first one. The default is 1.
+	MaxAttempts int `yaml:"max_attempts"`
+	// BaseDelay is the seconds to wait before the first retry, which doubles on each retry. The default is 1.
+	BaseDelay int `yaml:"base_delay"`
+	// MaxDelay is the maximum seconds to wait before a retry. The default is 300, or BaseDelay if it is greater.
+	MaxDelay int `yaml:"max_delay"`
+	// Jitter randomizes the delay by the fraction of itself, e.g. 0.2 for ±20%
+	Jitter float64 `yaml:"jitter"`
+}
+
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed cycles after which the collector is degraded
+	FailureThreshold int `yaml:"failure_threshold"`
+	// ProbeInterval is the interval in seconds to collect logs while the collector is degraded. The default is 300.
+	ProbeInterval int `yaml:"probe_interval"`
+}
+
+type ArchiveWatcherConfig struct {
+	// Name is the name of the watcher
+	Name string `yaml:"name"`
+	// Directory is the directory to watch for new .logarchive bundles
+	Directory string `yaml:"directory"`
+	// Predicate is the condition to get logs, which is a string passed to the --predicate option of the log command
+	Predicate string `yaml:"predicate"`
+	// Match is a structured form of Predicate, which is compiled to Predicate when the config is loaded
+	Match *PredicateMatch `yaml:"match,omitempty"`
+	// OutputFile is the file to write the logs to
+	OutputFile string `yaml:"output_file"`
+	// Outputs are the registered outputs receiving the logs in addition to OutputFile, which is optional if Outputs are set
+	Outputs []PluginConfig `yaml:"outputs,omitempty"`
+	// Processors are the registered processors applied in order to the logs delivered to Outputs
+	Processors []PluginConfig `yaml:"processors,omitempty"`
+	// StateFile is the file to record the bundles that have already been processed
+	StateFile string `yaml:"state_file"`
+	// Interval is the interval to scan the directory in seconds
+	Interval int `yaml:"interval"`
+	// StableSeconds is the number of seconds the size of a bundle must stay unchanged before it is processed
+	StableSeconds int `yaml:"stable_seconds"`
+	// MoveProcessed is a flag to move processed bundles to the done/ or failed/ subdirectory of Directory
+	MoveProcessed bool `yaml:"move_processed"`
 	// WithInfoLevel is a flag to enable the --info option of the log command
 	WithInfoLevel bool `yaml:"with_info_level"`
 }
@@ -45,46 +182,198 @@ func LoadConfigFromFile(filename string) (*Config, error) {
 }
 
 func ParseConfig(rawConfig []byte) (*Config, error) {
+	config, err := parseConfig(rawConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateConfig(config); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	return config, nil
+}
+
+// parseConfig parses the config and expands the predicates without validating it.
+func parseConfig(rawConfig []byte) (*Config, error) {
 	var config Config
 	if err := yaml.Unmarshal(rawConfig, &config); err != nil {
 		return nil, fmt.Errorf("error parsing config file: %v", err)
 	}
 
-	if err := validateConfig(&config); err != nil {
+	if err := expandPredicates(&config); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	if err := compileMatches(&config); err != nil {
 		return nil, fmt.Errorf("invalid config: %v", err)
 	}
 
 	return &config, nil
 }
 
-func validateConfig(config *Config) error {
-	if len(config.Collectors) == 0 {
-		return fmt.Errorf("no collectors defined")
+// Dump returns the config in YAML, in which predicate references are expanded and matches are compiled.
+// The predicate fragments and the matches are left out, so that the dump can be loaded as the config.
+func (c *Config) Dump() ([]byte, error) {
+	dumped := *c
+	dumped.Predicates = nil
+
+	dumped.Collectors = slices.Clone(c.Collectors)
+	for i := range dumped.Collectors {
+		dumped.Collectors[i].Match = nil
+	}
+	dumped.ArchiveWatchers = slices.Clone(c.ArchiveWatchers)
+	for i := range dumped.ArchiveWatchers {
+		dumped.ArchiveWatchers[i].Match = nil
 	}
 
-	if err := validateCollectorName(config.Collectors); err != nil {
-		return err
+	data, err := yaml.Marshal(&dumped)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling config: %v", err)
 	}
 
-	for _, c := range config.Collectors {
-		if err := validateOutputFile(c.OutputFile); err != nil {
-			return err
+	return data, nil
+}
+
+// expandPredicates expands the references to the predicate fragments in predicates of collectors and archive watchers.
+func expandPredicates(config *Config) error {
+	expander := newPredicateExpander(config.Predicates)
+
+	var errs []error
+
+	// expand all fragments to detect cycles and undefined references even if they are not used
+	for _, name := range sortedKeys(config.Predicates) {
+		if _, err := expander.expand("${" + name + "}"); err != nil {
+			errs = append(errs, fmt.Errorf("predicates %s: %v", name, err))
 		}
+	}
 
-		if err := validatePositionFile(c.PositionFile); err != nil {
-			return err
+	for i := range config.Collectors {
+		c := &config.Collectors[i]
+		predicate, err := expander.expand(c.Predicate)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("collector %s: %v", c.Name, err))
+			continue
 		}
+		c.Predicate = predicate
+	}
 
-		if err := validateInterval(c.Interval); err != nil {
-			return err
+	for i := range config.ArchiveWatchers {
+		w := &config.ArchiveWatchers[i]
+		predicate, err := expander.expand(w.Predicate)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("archive watcher %s: %v", w.Name, err))
+			continue
 		}
+		w.Predicate = predicate
+	}
 
-		if err := validatePredicate(c.Predicate); err != nil {
-			return err
+	return errors.Join(errs...)
+}
+
+// compileMatches compiles the structured match of collectors and archive watchers to the predicate.
+func compileMatches(config *Config) error {
+	var errs []error
+
+	for i := range config.Collectors {
+		c := &config.Collectors[i]
+		predicate, err := compileMatch(c.Match, c.Predicate)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("collector %s: %v", c.Name, err))
+			continue
 		}
+		c.Predicate = predicate
 	}
 
-	return nil
+	for i := range config.ArchiveWatchers {
+		w := &config.ArchiveWatchers[i]
+		predicate, err := compileMatch(w.Match, w.Predicate)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("archive watcher %s: %v", w.Name, err))
+			continue
+		}
+		w.Predicate = predicate
+	}
+
+	return errors.Join(errs...)
+}
+
+func compileMatch(match *PredicateMatch, predicate string) (string, error) {
+	if match == nil {
+		return predicate, nil
+	}
+
+	if predicate != "" {
+		return "", fmt.Errorf("predicate and match are mutually exclusive")
+	}
+
+	compiled, err := match.Compile()
+	if err != nil {
+		return "", fmt.Errorf("invalid match: %v", err)
+	}
+
+	return compiled, nil
+}
+
+// validateConfig validates the config and returns all errors found joined.
+func validateConfig(config *Config) error {
+	if len(config.Collectors) == 0 && len(config.ArchiveWatchers) == 0 {
+		return fmt.Errorf("no collectors defined")
+	}
+
+	errs := []error{validateCollectorName(config.Collectors)}
+
+	for _, c := range config.Collectors {
+		for _, err := range validateCollector(c) {
+			errs = append(errs, fmt.Errorf("collector %s: %w", c.Name, err))
+		}
+	}
+
+	errs = append(errs, validateArchiveWatchers(config.ArchiveWatchers), validateStateFiles(config))
+
+	if config.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout must not be negative"))
+	}
+
+	if config.MaxConcurrentCommands < 0 {
+		errs = append(errs, fmt.Errorf("max_concurrent_commands must not be negative"))
+	}
+
+	if config.Log != nil {
+		errs = append(errs, validateLogConfig(config.Log))
+	}
+
+	if config.HTTP != nil {
+		if config.HTTP.Listen == "" {
+			errs = append(errs, fmt.Errorf("http: listen is required"))
+		}
+		if config.HTTP.ReadyIntervals < 0 {
+			errs = append(errs, fmt.Errorf("http: ready_intervals must not be negative"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateCollector(c OSLogCollectorConfig) []error {
+	var outputFileErr error
+	if len(c.Outputs) == 0 {
+		outputFileErr = validateOutputFile(c.OutputFile)
+	}
+
+	return nonNilErrors(
+		outputFileErr,
+		validatePositionFile(c.PositionFile),
+		validateCommandTimeout(c.CommandTimeout),
+		validateCatchUpWindow(c.CatchUpWindow),
+		validateSchedulingOptions(c),
+		validateAdaptiveInterval(c),
+		validatePredicate(c.Predicate),
+		validateLogCommandOptions(c),
+		validateRetry(c.Retry),
+		validateCircuitBreaker(c.CircuitBreaker),
+		validatePlugins(c.Outputs, c.Processors),
+	)
 }
 
 func validateCollectorName(collectors []OSLogCollectorConfig) error {
@@ -99,6 +388,90 @@ func validateCollectorName(collectors []OSLogCollectorConfig) error {
 	return nil
 }
 
+// validateStateFiles returns an error for each position or state file shared by two collectors or archive watchers,
+// because the second one cannot take the lock of the file held by the first one.
+func validateStateFiles(config *Config) error {
+	var errs []error
+
+	owners := map[string]string{}
+	add := func(owner, key, path string) {
+		if path == "" {
+			return
+		}
+		path = filepath.Clean(path)
+		if other, ok := owners[path]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s %s is also used by %s", owner, key, path, other))
+			return
+		}
+		owners[path] = owner
+	}
+
+	for _, c := range config.Collectors {
+		add("collector "+c.Name, "position_file", c.PositionFile)
+	}
+	for _, w := range config.ArchiveWatchers {
+		add("archive watcher "+w.Name, "state_file", w.StateFile)
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateArchiveWatchers(watchers []ArchiveWatcherConfig) error {
+	var errs []error
+
+	names := map[string]struct{}{}
+	for _, w := range watchers {
+		if _, ok := names[w.Name]; ok {
+			errs = append(errs, fmt.Errorf("duplicate archive watcher name: %s", w.Name))
+		}
+		names[w.Name] = struct{}{}
+
+		for _, err := range validateArchiveWatcher(w) {
+			errs = append(errs, fmt.Errorf("archive watcher %s: %w", w.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateArchiveWatcher(w ArchiveWatcherConfig) []error {
+	var errs []error
+
+	if w.Directory == "" {
+		errs = append(errs, fmt.Errorf("directory is required"))
+	}
+
+	if w.StateFile == "" {
+		errs = append(errs, fmt.Errorf("state_file is required"))
+	}
+
+	if w.StableSeconds <= 0 {
+		errs = append(errs, fmt.Errorf("stable_seconds must be greater than 0"))
+	}
+
+	var outputFileErr error
+	if len(w.Outputs) == 0 {
+		outputFileErr = validateOutputFile(w.OutputFile)
+	}
+
+	return append(errs, nonNilErrors(
+		outputFileErr,
+		validateInterval(w.Interval),
+		validatePredicate(w.Predicate),
+		validatePlugins(w.Outputs, w.Processors),
+	)...)
+}
+
+func nonNilErrors(errs ...error) []error {
+	result := make([]error, 0, len(errs))
+	for _, err := range errs {
+		if err != nil {
+			result = append(result, err)
+		}
+	}
+	return result
+}
+
 func validateOutputFile(outputFile string) error {
 	if outputFile == "" {
 		return fmt.Errorf("output_file is required")
@@ -115,6 +488,14 @@ func validatePositionFile(positionFile string) error {
 	return nil
 }
 
+func validateCommandTimeout(timeout int) error {
+	if timeout < 0 {
+		return fmt.Errorf("command_timeout must not be negative")
+	}
+
+	return nil
+}
+
 func validateInterval(interval int) error {
 	if interval <= 0 {
 		return fmt.Errorf("interval must be greater than 0")
@@ -123,10 +504,26 @@ func validateInterval(interval int) error {
 	return nil
 }
 
+func validateLogCommandOptions(config OSLogCollectorConfig) error {
+	now := flextime.Now().Format(PositionTimeFormat)
+
+	// validate the command of the first collection, which is the only one that may use --last
+	startTime := now
+	if config.Last != "" {
+		startTime = ""
+	}
+
+	return newOSLogCollector(config).newLogCommandBuilder(startTime, now).Validate()
+}
+
 func validatePredicate(predicate string) error {
 	if predicate == "" {
 		return fmt.Errorf("predicate is required")
 	}
 
+	if err := ValidatePredicate(predicate); err != nil {
+		return fmt.Errorf("invalid predicate %q: %v", predicate, err)
+	}
+
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 
 	oslog_collector "github.com/mrtc0/oslog-collector"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseConfig(t *testing.T) {
@@ -19,11 +20,85 @@ func TestParseConfig(t *testing.T) {
 			config:    validConfig,
 			expectErr: false,
 		},
+		"when config has only archive watchers": {
+			config:    archiveWatcherConfig,
+			expectErr: false,
+		},
+		"when archive watcher has no stable_seconds": {
+			config:           archiveWatcherWithoutStableSecondsConfig,
+			expectErr:        true,
+			expectErrMessage: "stable_seconds must be greater than 0",
+		},
+		"when collector has log command options": {
+			config:    logCommandOptionsConfig,
+			expectErr: false,
+		},
+		"when collector has invalid color": {
+			config:           invalidColorConfig,
+			expectErr:        true,
+			expectErrMessage: "invalid --color value",
+		},
+		"when collector has match": {
+			config:    matchConfig,
+			expectErr: false,
+		},
+		"when collector has both predicate and match": {
+			config:           predicateAndMatchConfig,
+			expectErr:        true,
+			expectErrMessage: "collector foo: predicate and match are mutually exclusive",
+		},
+		"when collector has invalid predicate": {
+			config:           invalidPredicateConfig,
+			expectErr:        true,
+			expectErrMessage: `invalid predicate "subsystem = 'com.apple.mdns' AND": column 33: expected a key, but got end of predicate`,
+		},
+		"when collector has retry and circuit breaker": {
+			config:    retryConfig,
+			expectErr: false,
+		},
+		"when retry has max_delay less than base_delay": {
+			config:           invalidRetryConfig,
+			expectErr:        true,
+			expectErrMessage: "retry: max_delay must be greater than or equal to base_delay",
+		},
+		"when collector has invalid interval_jitter": {
+			config:           invalidIntervalJitterConfig,
+			expectErr:        true,
+			expectErrMessage: "collector foo: interval_jitter must be greater than or equal to 0 and less than 1",
+		},
+		"when collector has schedule": {
+			config:    scheduleConfig,
+			expectErr: false,
+		},
+		"when collector has both interval and schedule": {
+			config:           intervalAndScheduleConfig,
+			expectErr:        true,
+			expectErrMessage: "collector foo: interval and schedule are mutually exclusive",
+		},
+		"when collector has adaptive interval": {
+			config:    adaptiveIntervalConfig,
+			expectErr: false,
+		},
+		"when interval is out of adaptive interval range": {
+			config:           invalidAdaptiveIntervalConfig,
+			expectErr:        true,
+			expectErrMessage: "collector foo: interval must be between min_interval and max_interval",
+		},
 		"when config has duplicate collector name": {
 			config:           duplicateCollectorNameConfig,
 			expectErr:        true,
 			expectErrMessage: "duplicate collector name: foo",
 		},
+		"when collectors share a position file": {
+			config:           duplicatePositionFileConfig,
+			expectErr:        true,
+			expectErrMessage: "collector bar: position_file /var/lib/oslog-collector/foo.pos is also used by collector foo",
+		},
+		"when an archive watcher shares the position file of a collector": {
+			config:           duplicateStateFileConfig,
+			expectErr:        true,
+			expectErrMessage: "archive watcher baz: state_file /var/lib/oslog-collector/foo.pos is also used by collector foo",
+		},
 	}
 
 	for name, tt := range testCases {
@@ -75,4 +150,387 @@ collectors:
     predicate: "process == 'bar'"
     with_info_level: true
 `
+
+	duplicatePositionFileConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'foo'"
+  - name: bar
+    output_file: /var/log/bar.log
+    position_file: /var/lib/oslog-collector/../oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'bar'"
+`
+
+	duplicateStateFileConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'foo'"
+archive_watchers:
+  - name: baz
+    directory: /var/lib/oslog-collector/drop
+    output_file: /var/log/baz.log
+    state_file: /var/lib/oslog-collector/foo.pos
+    interval: 10
+    stable_seconds: 30
+    predicate: "process == 'baz'"
+`
+
+	archiveWatcherConfig = `
+pid_file: /var/run/oslog-collector.pid
+archive_watchers:
+  - name: incident-response
+    directory: /var/lib/oslog-collector/drop
+    output_file: /var/log/ir.log
+    state_file: /var/lib/oslog-collector/ir.state
+    interval: 10
+    stable_seconds: 30
+    move_processed: true
+    predicate: "process == 'foo'"
+`
+
+	archiveWatcherWithoutStableSecondsConfig = `
+pid_file: /var/run/oslog-collector.pid
+archive_watchers:
+  - name: incident-response
+    directory: /var/lib/oslog-collector/drop
+    output_file: /var/log/ir.log
+    state_file: /var/lib/oslog-collector/ir.state
+    interval: 10
+    predicate: "process == 'foo'"
+`
+
+	logCommandOptionsConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'foo'"
+    with_debug_level: true
+    with_signpost: true
+    process: foo
+    with_source: true
+    timezone: UTC
+    no_backtrace: true
+    mach_continuous_time: true
+    last: 1h
+    color: none
+`
+
+	invalidColorConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'foo'"
+    color: never
+`
+
+	matchConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    match:
+      subsystem: com.apple.mdns
+      message_level: [error, fault]
+`
+
+	invalidPredicateConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "subsystem = 'com.apple.mdns' AND"
+`
+
+	retryConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'foo'"
+    retry:
+      max_attempts: 3
+      base_delay: 1
+      max_delay: 10
+      jitter: 0.2
+    circuit_breaker:
+      failure_threshold: 5
+      probe_interval: 600
+`
+
+	invalidRetryConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'foo'"
+    retry:
+      max_attempts: 3
+      base_delay: 10
+      max_delay: 5
+`
+
+	invalidIntervalJitterConfig = `
+max_concurrent_commands: 2
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'foo'"
+    priority: 10
+    splay: 30
+    interval_jitter: 1.5
+`
+
+	scheduleConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    schedule: "*/5 * * * *"
+    predicate: "process == 'foo'"
+  - name: bar
+    output_file: /var/log/bar.log
+    position_file: /var/lib/oslog-collector/bar.pos
+    interval: 300
+    align: true
+    predicate: "process == 'bar'"
+`
+
+	intervalAndScheduleConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 300
+    schedule: "*/5 * * * *"
+    predicate: "process == 'foo'"
+`
+
+	adaptiveIntervalConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    min_interval: 10
+    max_interval: 600
+    predicate: "process == 'foo'"
+`
+
+	invalidAdaptiveIntervalConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 5
+    min_interval: 10
+    max_interval: 600
+    predicate: "process == 'foo'"
+`
+
+	predicateAndMatchConfig = `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'foo'"
+    match:
+      subsystem: com.apple.mdns
+`
 )
+
+func TestParseConfig_Match(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := oslog_collector.ParseConfig([]byte(matchConfig))
+	assert.NoError(t, err)
+	assert.Equal(t, `subsystem == "com.apple.mdns" AND (messageType == error OR messageType == fault)`, cfg.Collectors[0].Predicate)
+}
+
+func TestParseConfig_Predicates(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		config           string
+		expectPredicates []string
+		expectErr        bool
+		expectErrMessage string
+	}{
+		"when predicates are referenced": {
+			config: `
+predicates:
+  security_subsystems: "subsystem == 'com.apple.securityd' OR subsystem == 'com.apple.authd'"
+  errors: "messageType == error"
+  security_errors: "${security_subsystems} AND ${errors}"
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "${security_subsystems} AND messageType == error"
+  - name: bar
+    output_file: /var/log/bar.log
+    position_file: /var/lib/oslog-collector/bar.pos
+    interval: 60
+    predicate: "${security_errors} AND NOT eventMessage CONTAINS '${errors}'"
+`,
+			expectPredicates: []string{
+				"(subsystem == 'com.apple.securityd' OR subsystem == 'com.apple.authd') AND messageType == error",
+				"((subsystem == 'com.apple.securityd' OR subsystem == 'com.apple.authd') AND messageType == error) AND NOT eventMessage CONTAINS '${errors}'",
+			},
+		},
+		"when predicates use lowercase and symbolic operators": {
+			config: `
+predicates:
+  lowercase: "subsystem == 'com.apple.securityd' or subsystem == 'com.apple.authd'"
+  symbolic: "process == 'sshd' || process == 'sudo'"
+  grouped: "(process == 'sshd' || process == 'sudo')"
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "${lowercase} and messageType == error"
+  - name: bar
+    output_file: /var/log/bar.log
+    position_file: /var/lib/oslog-collector/bar.pos
+    interval: 60
+    predicate: "${symbolic} && messageType == error"
+  - name: baz
+    output_file: /var/log/baz.log
+    position_file: /var/lib/oslog-collector/baz.pos
+    interval: 60
+    predicate: "${grouped} && messageType == error"
+`,
+			expectPredicates: []string{
+				"(subsystem == 'com.apple.securityd' or subsystem == 'com.apple.authd') and messageType == error",
+				"(process == 'sshd' || process == 'sudo') && messageType == error",
+				"(process == 'sshd' || process == 'sudo') && messageType == error",
+			},
+		},
+		"when predicate is undefined": {
+			config: `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "${security_subsystems} AND messageType == error"
+`,
+			expectErr:        true,
+			expectErrMessage: "collector foo: undefined predicate: ${security_subsystems}",
+		},
+		"when predicates have a cycle": {
+			config: `
+predicates:
+  a: "${b} OR process == 'a'"
+  b: "${c} OR process == 'b'"
+  c: "${a} OR process == 'c'"
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "process == 'foo'"
+`,
+			expectErr:        true,
+			expectErrMessage: "predicate reference cycle: a -> b -> c -> a",
+		},
+		"when predicate reference is not terminated": {
+			config: `
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "${errors AND process == 'foo'"
+`,
+			expectErr:        true,
+			expectErrMessage: "unterminated predicate reference at column 1",
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := oslog_collector.ParseConfig([]byte(tt.config))
+			if tt.expectErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMessage)
+				return
+			}
+
+			assert.NoError(t, err)
+			for i, predicate := range tt.expectPredicates {
+				assert.Equal(t, predicate, cfg.Collectors[i].Predicate)
+			}
+
+			dump, err := cfg.Dump()
+			assert.NoError(t, err)
+			assert.Contains(t, string(dump), tt.expectPredicates[0])
+		})
+	}
+}
+
+func TestConfig_Dump(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := oslog_collector.ParseConfig([]byte(`
+predicates:
+  errors: "messageType == error"
+collectors:
+  - name: foo
+    output_file: /var/log/foo.log
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60
+    predicate: "${errors} AND process == 'foo'"
+  - name: bar
+    output_file: /var/log/bar.log
+    position_file: /var/lib/oslog-collector/bar.pos
+    interval: 60
+    match:
+      subsystem: com.apple.mdns
+archive_watchers:
+  - name: baz
+    directory: /var/lib/oslog-collector/drop
+    output_file: /var/log/baz.log
+    state_file: /var/lib/oslog-collector/baz.state
+    interval: 10
+    stable_seconds: 30
+    match:
+      process: baz
+`))
+	require.NoError(t, err)
+
+	dump, err := cfg.Dump()
+	require.NoError(t, err)
+	assert.NotContains(t, string(dump), "match:")
+	assert.NotContains(t, string(dump), "predicates:")
+
+	loaded, err := oslog_collector.ParseConfig(dump)
+	require.NoError(t, err, "the dump is loaded as the config")
+	assert.Equal(t, cfg.Collectors[0].Predicate, loaded.Collectors[0].Predicate)
+	assert.Equal(t, cfg.Collectors[1].Predicate, loaded.Collectors[1].Predicate)
+	assert.Equal(t, cfg.ArchiveWatchers[0].Predicate, loaded.ArchiveWatchers[0].Predicate)
+	assert.NotNil(t, cfg.Collectors[1].Match, "the dump does not change the config")
+}
@@ -0,0 +1,318 @@
+package oslog_collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	controlSocketMode     = 0o600
+	controlReadTimeout    = 10 * time.Second
+	controlDialTimeout    = 5 * time.Second
+	controlCommandPause   = "pause"
+	controlCommandResume  = "resume"
+	controlCommandTrigger = "trigger"
+	controlCommandReopen  = "reopen"
+	controlCommandReload  = "reload"
+	controlCommandStatus  = "status"
+	controlCommandFlush   = "flush"
+)
+
+// ControlCommands is the list of the commands accepted by the control socket.
+var ControlCommands = []string{
+	controlCommandPause, controlCommandResume, controlCommandTrigger,
+	controlCommandReopen, controlCommandReload, controlCommandStatus, controlCommandFlush,
+}
+
+// ControlRequest is a command sent to the control socket as a line of JSON.
+type ControlRequest struct {
+	Command string `json:"command"`
+	// Collector is the collector to pause, resume or trigger, or all collectors if empty
+	Collector string `json:"collector,omitempty"`
+}
+
+// ControlResponse is the response to a ControlRequest written as a line of JSON.
+type ControlResponse struct {
+	OK      bool         `json:"ok"`
+	Message string       `json:"message,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Status  *AgentStatus `json:"status,omitempty"`
+}
+
+type controlServer struct {
+	path     string
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// startControlServer listens on the control socket in the background if it is configured, and returns nil if it is not.
+func (a *Agent) startControlServer(ctx context.Context) (*controlServer, error) {
+	path := a.Config.ControlSocket
+	if path == "" {
+		return nil, nil
+	}
+
+	if err := removeStaleControlSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := listenControlSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &controlServer{path: path, listener: listener}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		server.serve(ctx, a)
+	}()
+
+	slog.Info("Control socket started", "control_socket", path)
+	return server, nil
+}
+
+func (s *controlServer) serve(ctx context.Context, a *Agent) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("Error accepting control connection", "error", err)
+			}
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			handleControlConn(ctx, a, conn)
+		}()
+	}
+}
+
+// listenControlSocket creates the control socket in a temporary directory only the agent can access, and moves it to
+// the path after restricting its mode, so that other users cannot connect before the mode is changed.
+func listenControlSocket(path string) (net.Listener, error) {
+	// os.MkdirTemp creates the directory with the mode 0700
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".ctl")
+	if err != nil {
+		return nil, fmt.Errorf("error creating control socket %s: %v", path, err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "s")
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on control socket %s: %v", path, err)
+	}
+	// the socket is removed from the path by stop, not from tmpPath by Close
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmpPath, controlSocketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error changing the mode of control socket %s: %v", path, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error creating control socket %s: %v", path, err)
+	}
+
+	return listener, nil
+}
+
+// stop closes the control socket and waits for the running commands.
+func (s *controlServer) stop() {
+	if s == nil {
+		return
+	}
+
+	s.listener.Close()
+	s.wg.Wait()
+
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		slog.Error("Error removing control socket", "control_socket", s.path, "error", err)
+	}
+}
+
+func handleControlConn(ctx context.Context, a *Agent, conn net.Conn) {
+	var response ControlResponse
+
+	conn.SetReadDeadline(time.Now().Add(controlReadTimeout))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		response = ControlResponse{Error: fmt.Sprintf("error reading request: %v", err)}
+	} else {
+		var request ControlRequest
+		if err := json.Unmarshal(line, &request); err != nil {
+			response = ControlResponse{Error: fmt.Sprintf("invalid request: %v", err)}
+		} else {
+			slog.Info("Received control command", "command", request.Command, "collector_name", request.Collector)
+			response = a.Control(ctx, request)
+		}
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		slog.Error("Error writing control response", "error", err)
+	}
+}
+
+// Control runs a command received on the control socket.
+func (a *Agent) Control(ctx context.Context, request ControlRequest) ControlResponse {
+	switch request.Command {
+	case controlCommandPause, controlCommandResume, controlCommandTrigger:
+		collectors, err := a.selectRunningCollectors(request.Collector)
+		if err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+
+		names := make([]string, 0, len(collectors))
+		for _, c := range collectors {
+			switch request.Command {
+			case controlCommandPause:
+				c.Pause()
+			case controlCommandResume:
+				c.Resume()
+			case controlCommandTrigger:
+				c.Trigger()
+			}
+			names = append(names, c.Name)
+		}
+
+		return ControlResponse{OK: true, Message: request.Command + ": " + strings.Join(names, ", ")}
+	case controlCommandReopen:
+		if err := a.reopenLogFiles(); err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		return ControlResponse{OK: true, Message: "reopened output files and log file"}
+	case controlCommandReload:
+		if err := a.Reload(ctx); err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		return ControlResponse{OK: true, Message: "reloaded config"}
+	case controlCommandStatus:
+		status := a.Status()
+		return ControlResponse{OK: true, Status: &status}
+	case controlCommandFlush:
+		if err := a.flush(); err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		return ControlResponse{OK: true, Message: "flushed output files"}
+	default:
+		return ControlResponse{Error: fmt.Sprintf("unknown command %q, must be one of %s", request.Command, strings.Join(ControlCommands, ", "))}
+	}
+}
+
+// selectRunningCollectors returns the running collector with the name, or all running collectors if the name is empty.
+func (a *Agent) selectRunningCollectors(name string) ([]*OSLogCollector, error) {
+	collectors := a.Collectors()
+	if name == "" {
+		return collectors, nil
+	}
+
+	for _, c := range collectors {
+		if c.Name == name {
+			return []*OSLogCollector{c}, nil
+		}
+	}
+	return nil, fmt.Errorf("collector not found: %s", name)
+}
+
+// flush commits the output files of the collectors and archive watchers to the disk.
+func (a *Agent) flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var errs []error
+	for _, collector := range a.LogCollectors {
+		errs = append(errs, prefixError("collector "+collector.Name, collector.Flush()))
+	}
+	for _, watcher := range a.ArchiveWatchers {
+		errs = append(errs, prefixError("archive watcher "+watcher.Name, watcher.Flush()))
+	}
+	return errors.Join(errs...)
+}
+
+// removeStaleControlSocket removes the control socket left by an agent that exited without removing it.
+func removeStaleControlSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error checking control socket %s: %v", path, err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("control socket %s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, controlDialTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is used by another process", path)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("error removing stale control socket %s: %v", path, err)
+	}
+	return nil
+}
+
+// SendControlCommand sends the request to the control socket of the agent and returns the response.
+// It refuses to use a socket that other users can access or that is owned by another user.
+func SendControlCommand(path string, request ControlRequest) (*ControlResponse, error) {
+	if err := checkControlSocket(path); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", path, controlDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to control socket: %v", err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fmt.Errorf("error sending control command: %v", err)
+	}
+
+	var response ControlResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error reading control response: %v", err)
+	}
+
+	return &response, nil
+}
+
+func checkControlSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error checking control socket: %v", err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a socket", path)
+	}
+
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("control socket %s is accessible by other users (mode %04o), it must be %04o", path, perm, controlSocketMode)
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if uid := os.Getuid(); uid != 0 && int(stat.Uid) != uid {
+			return fmt.Errorf("control socket %s is owned by another user (uid %d)", path, stat.Uid)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package oslog_collector_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_Control(t *testing.T) {
+	// the path of a Unix domain socket is limited to about 100 bytes
+	socketDir, err := os.MkdirTemp("", "oslog-collector")
+	require.NoError(t, err)
+	defer os.RemoveAll(socketDir)
+
+	workdir := t.TempDir()
+	socketPath := filepath.Join(socketDir, "control.sock")
+	configFile := filepath.Join(workdir, "oslog-collector.conf")
+
+	require.NoError(t, os.WriteFile(configFile, []byte(fmt.Sprintf(`
+control_socket: %[1]s
+collectors:
+  - name: foo
+    predicate: "process == 'foo'"
+    output_file: %[2]s/foo.log
+    position_file: %[2]s/foo.pos
+    interval: 3600
+    splay: 0
+  - name: bar
+    predicate: "process == 'bar'"
+    output_file: %[2]s/bar.log
+    position_file: %[2]s/bar.pos
+    interval: 3600
+    splay: 0
+`, socketPath, workdir)), 0644))
+
+	writePastPosition(t, filepath.Join(workdir, "foo.pos"))
+	writePastPosition(t, filepath.Join(workdir, "bar.pos"))
+
+	runsCh := make(chan string, 10)
+	dummyRunnerGenerator := func(args []string) oslog_collector.LogCommandRunner {
+		runsCh <- args[3]
+		return testLogRunner
+	}
+
+	agent, err := oslog_collector.NewAgentFromConfigFile(configFile, oslog_collector.WithCollectorOptions(oslog_collector.WithLogCommandRunner(dummyRunnerGenerator)))
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- agent.Run()
+	}()
+
+	// both collectors run once at startup
+	assert.ElementsMatch(t, []string{"process == 'foo'", "process == 'bar'"}, []string{<-runsCh, <-runsCh})
+
+	send := func(command, collector string) *oslog_collector.ControlResponse {
+		t.Helper()
+		response, err := oslog_collector.SendControlCommand(socketPath, oslog_collector.ControlRequest{Command: command, Collector: collector})
+		require.NoError(t, err)
+		return response
+	}
+
+	t.Run("socket is only accessible by the owner", func(t *testing.T) {
+		info, err := os.Stat(socketPath)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		// the temporary directory the socket was created in is removed
+		entries, err := os.ReadDir(socketDir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "control.sock", entries[0].Name())
+	})
+
+	t.Run("pause and resume", func(t *testing.T) {
+		response := send("pause", "foo")
+		assert.True(t, response.OK)
+		assert.Equal(t, "pause: foo", response.Message)
+
+		status := send("status", "").Status
+		require.NotNil(t, status)
+		assert.True(t, status.Collectors[0].Paused)
+		assert.False(t, status.Collectors[1].Paused)
+
+		response = send("resume", "")
+		assert.Equal(t, "resume: foo, bar", response.Message)
+		assert.False(t, send("status", "").Status.Collectors[0].Paused)
+	})
+
+	t.Run("trigger", func(t *testing.T) {
+		// move the clock forward, so that the triggered window is not empty
+		flextime.Set(time.Now().Add(time.Minute))
+		defer flextime.Restore()
+
+		response := send("trigger", "bar")
+		assert.True(t, response.OK)
+
+		select {
+		case predicate := <-runsCh:
+			assert.Equal(t, "process == 'bar'", predicate)
+		case <-time.After(5 * time.Second):
+			t.Fatal("collection is not triggered")
+		}
+	})
+
+	t.Run("reopen, reload and flush", func(t *testing.T) {
+		for _, command := range []string{"reopen", "reload", "flush"} {
+			response := send(command, "")
+			assert.True(t, response.OK, command)
+			assert.Empty(t, response.Error, command)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		response := send("pause", "baz")
+		assert.False(t, response.OK)
+		assert.Equal(t, "collector not found: baz", response.Error)
+
+		response = send("restart", "")
+		assert.False(t, response.OK)
+		assert.Equal(t, `unknown command "restart", must be one of pause, resume, trigger, reopen, reload, status, flush`, response.Error)
+	})
+
+	t.Run("when socket is accessible by other users then refuse to connect", func(t *testing.T) {
+		require.NoError(t, os.Chmod(socketPath, 0o666))
+		defer os.Chmod(socketPath, 0o600)
+
+		_, err := oslog_collector.SendControlCommand(socketPath, oslog_collector.ControlRequest{Command: "status"})
+		assert.EqualError(t, err, fmt.Sprintf("control socket %s is accessible by other users (mode 0666), it must be 0600", socketPath))
+	})
+
+	agent.ShutdownCh <- struct{}{}
+	require.NoError(t, <-done)
+
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err), "control socket is removed on shutdown")
+}
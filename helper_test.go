@@ -0,0 +1,139 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/require"
+)
+
+// runnerFunc is a LogCommandRunner that calls the function, so that each test defines the log command it needs.
+type runnerFunc func(ctx context.Context) ([]byte, error)
+
+func (f runnerFunc) RunLogCommand(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// outputRunner returns a runner that outputs the data.
+func outputRunner(data string) runnerFunc {
+	return func(ctx context.Context) ([]byte, error) {
+		return []byte(data), nil
+	}
+}
+
+// testLogRunner outputs a line that is not an entry, which is written to the output file as is.
+var testLogRunner = outputRunner("test log ")
+
+// failingRunner fails like the log command that cannot open the archive.
+var failingRunner = runnerFunc(func(ctx context.Context) ([]byte, error) {
+	return []byte("log: Could not open archive"), errors.New("exit status 64")
+})
+
+// manualClock is a flextime clock that advances only when Advance is called, so that the timers of the collectors
+// fire at the exact times of the test.
+type manualClock struct {
+	mu       sync.Mutex
+	start    time.Time
+	now      time.Time
+	sleepers []*manualSleeper
+	closed   bool
+}
+
+type manualSleeper struct {
+	until time.Time
+	wake  chan struct{}
+}
+
+// newManualClock switches flextime to a manual clock starting at now. The clock is closed when the test ends,
+// and Close must be called before waiting for the collectors to stop, because a stopped timer waits for its sleep.
+func newManualClock(t *testing.T, now time.Time) *manualClock {
+	clock := &manualClock{start: now, now: now}
+	restore := flextime.Switch(flextime.NewFakeClock(clock))
+	t.Cleanup(func() {
+		clock.Close()
+		restore()
+	})
+	return clock
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Sleep blocks until the clock is advanced by d, or the clock is closed.
+func (c *manualClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	if c.closed || d <= 0 {
+		c.mu.Unlock()
+		return
+	}
+	sleeper := &manualSleeper{until: c.now.Add(d), wake: make(chan struct{})}
+	c.sleepers = append(c.sleepers, sleeper)
+	c.mu.Unlock()
+
+	<-sleeper.wake
+}
+
+// Monotonic returns the time elapsed on the clock, which is passed to WithMonotonicClock so that advancing the clock
+// is not detected as a jump of the wall clock.
+func (c *manualClock) Monotonic() time.Duration {
+	return c.Now().Sub(c.start)
+}
+
+// Advance advances the clock by d and wakes up the sleepers whose time has come.
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	sleepers := c.sleepers[:0]
+	for _, sleeper := range c.sleepers {
+		if sleeper.until.After(c.now) {
+			sleepers = append(sleepers, sleeper)
+			continue
+		}
+		close(sleeper.wake)
+	}
+	c.sleepers = sleepers
+}
+
+// WaitForTimers waits until n timers are waiting for the clock.
+func (c *manualClock) WaitForTimers(t *testing.T, n int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return len(c.sleepers) >= n
+	}, 5*time.Second, time.Millisecond)
+}
+
+// Close wakes up the sleepers, and makes the following sleeps return immediately.
+func (c *manualClock) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, sleeper := range c.sleepers {
+		close(sleeper.wake)
+	}
+	c.sleepers = nil
+}
+
+// writePastPosition writes the position a minute before now, so that the first collection of a new collector runs
+// the log command instead of skipping the empty window from now to now.
+func writePastPosition(t *testing.T, positionFile string) {
+	t.Helper()
+
+	position := oslog_collector.Position{LastTimestamp: flextime.Now().Add(-time.Minute).Format(oslog_collector.PositionTimeFormat)}
+	require.NoError(t, oslog_collector.WritePosition(positionFile, position))
+}
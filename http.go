@@ -0,0 +1,113 @@
+package oslog_collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const httpShutdownTimeout = 5 * time.Second
+
+// Handler returns the HTTP handler of the agent, which serves the metrics on /metrics,
+// the liveness on /healthz, the readiness on /readyz and the status of the collectors on /status.
+func (a *Agent) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", a.Metrics)
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+	mux.HandleFunc("/status", a.handleStatus)
+	return mux
+}
+
+func (a *Agent) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz succeeds if every collector that is not paused has succeeded, and every archive watcher has scanned
+// its directory, within the ready intervals.
+func (a *Agent) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	readyIntervals := a.readyIntervals()
+	agentStatus := a.Status()
+
+	var reasons []string
+	for _, status := range agentStatus.Collectors {
+		if !status.Ready && !status.Paused {
+			reasons = append(reasons, status.notReadyReason(readyIntervals))
+		}
+	}
+	for _, status := range agentStatus.ArchiveWatchers {
+		if !status.Ready {
+			reasons = append(reasons, status.notReadyReason(readyIntervals))
+		}
+	}
+
+	if len(reasons) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(reasons, "\n"))
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+func (a *Agent) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.Status()); err != nil {
+		slog.Error("Error writing status", "error", err)
+	}
+}
+
+func (a *Agent) readyIntervals() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.Config == nil || a.Config.HTTP == nil || a.Config.HTTP.ReadyIntervals == 0 {
+		return defaultReadyIntervals
+	}
+	return a.Config.HTTP.ReadyIntervals
+}
+
+// startHTTPServer starts the HTTP listener in the background if it is configured, and returns nil if it is not.
+func (a *Agent) startHTTPServer() (*http.Server, error) {
+	if a.Config.HTTP == nil {
+		return nil, nil
+	}
+
+	listener, err := net.Listen("tcp", a.Config.HTTP.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %v", a.Config.HTTP.Listen, err)
+	}
+
+	server := &http.Server{
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error serving HTTP", "error", err)
+		}
+	}()
+
+	slog.Info("HTTP server started", "listen", listener.Addr().String())
+	return server, nil
+}
+
+func stopHTTPServer(server *http.Server) {
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Error stopping HTTP server", "error", err)
+	}
+}
@@ -0,0 +1,142 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_Handler(t *testing.T) {
+	flextime.Fix(time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC))
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+
+	newCollector := func(name, schedule string, runner oslog_collector.LogCommandRunner) *oslog_collector.OSLogCollector {
+		writePastPosition(t, filepath.Join(workdir, name+".pos"))
+		collector, err := oslog_collector.NewOSLogCollector(oslog_collector.OSLogCollectorConfig{
+			Name:         name,
+			Predicate:    "process == '" + name + "'",
+			OutputFile:   filepath.Join(workdir, name+".log"),
+			PositionFile: filepath.Join(workdir, name+".pos"),
+			Interval:     60,
+			Schedule:     schedule,
+		}, oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner { return runner }))
+		require.NoError(t, err)
+		t.Cleanup(func() { collector.Close() })
+		return collector
+	}
+
+	foo := newCollector("foo", "", testLogRunner)
+	barFails := true
+	bar := newCollector("bar", "", runnerFunc(func(ctx context.Context) ([]byte, error) {
+		if barFails {
+			return failingRunner(ctx)
+		}
+		return testLogRunner(ctx)
+	}))
+	// baz is not due until the next day, and is ready until then without running
+	baz := newCollector("baz", "@daily", testLogRunner)
+
+	watcher, err := oslog_collector.NewArchiveWatcher(oslog_collector.ArchiveWatcherConfig{
+		Name:       "qux",
+		Directory:  workdir,
+		Predicate:  "process == 'qux'",
+		OutputFile: filepath.Join(workdir, "qux.log"),
+		StateFile:  filepath.Join(workdir, "qux.state"),
+		Interval:   60,
+	})
+	require.NoError(t, err)
+	defer watcher.Close()
+
+	agent := &oslog_collector.Agent{
+		Config:          &oslog_collector.Config{HTTP: &oslog_collector.HTTPConfig{Listen: "127.0.0.1:0", ReadyIntervals: 2}},
+		LogCollectors:   []*oslog_collector.OSLogCollector{foo, bar, baz},
+		ArchiveWatchers: []*oslog_collector.ArchiveWatcher{watcher},
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		agent.Handler().ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+		return response
+	}
+
+	require.NoError(t, foo.CollectLogs(context.Background()))
+	require.Error(t, bar.CollectLogs(context.Background()))
+	flextime.Fix(flextime.Now().Add(30 * time.Second))
+
+	t.Run("healthz", func(t *testing.T) {
+		response := get("/healthz")
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "ok\n", response.Body.String())
+	})
+
+	t.Run("readyz when a collector has not succeeded", func(t *testing.T) {
+		response := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+		assert.Equal(t, "collector bar has not succeeded yet\n", response.Body.String())
+	})
+
+	t.Run("status", func(t *testing.T) {
+		response := get("/status")
+		require.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+
+		var status oslog_collector.AgentStatus
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &status))
+		require.Len(t, status.Collectors, 3)
+
+		assert.Equal(t, "foo", status.Collectors[0].Name)
+		assert.Equal(t, "process == 'foo'", status.Collectors[0].Predicate)
+		assert.Equal(t, "2025-01-29T00:00:00Z", status.Collectors[0].LastTimestamp)
+		assert.Equal(t, 30.0, *status.Collectors[0].LagSeconds)
+		assert.NotNil(t, status.Collectors[0].LastSuccess)
+		assert.Nil(t, status.Collectors[0].LastFailure)
+		assert.True(t, status.Collectors[0].Ready)
+
+		assert.Equal(t, "bar", status.Collectors[1].Name)
+		assert.Nil(t, status.Collectors[1].LastSuccess)
+		assert.NotNil(t, status.Collectors[1].LastFailure)
+		assert.Contains(t, status.Collectors[1].LastError, "exit status 64")
+		assert.False(t, status.Collectors[1].Ready)
+
+		assert.Equal(t, "baz", status.Collectors[2].Name)
+		assert.Nil(t, status.Collectors[2].LastSuccess)
+		assert.True(t, status.Collectors[2].Ready)
+
+		require.Len(t, status.ArchiveWatchers, 1)
+		assert.Equal(t, "qux", status.ArchiveWatchers[0].Name)
+		assert.Equal(t, workdir, status.ArchiveWatchers[0].Directory)
+		assert.Equal(t, []string{}, status.ArchiveWatchers[0].PendingArchives)
+		assert.Nil(t, status.ArchiveWatchers[0].LastSuccess)
+		assert.True(t, status.ArchiveWatchers[0].Ready)
+	})
+
+	t.Run("readyz when all collectors have succeeded within the ready intervals", func(t *testing.T) {
+		barFails = false
+		require.NoError(t, bar.CollectLogs(context.Background()))
+
+		response := get("/readyz")
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "ok\n", response.Body.String())
+	})
+
+	t.Run("readyz when collectors have not succeeded within the ready intervals", func(t *testing.T) {
+		flextime.Fix(flextime.Now().Add(121 * time.Second))
+
+		response := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+		assert.Equal(t, "collector foo has not succeeded in the last 2 intervals\n"+
+			"collector bar has not succeeded in the last 2 intervals\n"+
+			"archive watcher qux has not scanned its directory in the first 2 intervals\n", response.Body.String())
+	})
+}
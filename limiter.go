@@ -0,0 +1,109 @@
+package oslog_collector
+
+import (
+	"context"
+	"sync"
+)
+
+// CommandLimiter limits the number of log commands running at the same time across the collectors and archive
+// watchers sharing it. Waiting commands get a slot in the order of their priority, and in the order of arrival
+// among the same priority. All methods can be called on a nil *CommandLimiter, which does not limit anything.
+type CommandLimiter struct {
+	max     int
+	metrics *Metrics
+
+	mu      sync.Mutex
+	running int
+	waiters []*commandWaiter
+}
+
+type commandWaiter struct {
+	priority int
+	ready    chan struct{}
+}
+
+// NewCommandLimiter creates a limiter that runs at most max log commands at the same time.
+// It returns nil, which does not limit anything, if max is 0.
+func NewCommandLimiter(max int) *CommandLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &CommandLimiter{max: max}
+}
+
+// acquire waits for a slot to run a log command, and returns the function to release it.
+// It returns the error of the context if the context is done before a slot is available.
+func (l *CommandLimiter) acquire(ctx context.Context, priority int) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	if l.running < l.max && len(l.waiters) == 0 {
+		l.running++
+		l.mu.Unlock()
+		return l.release, nil
+	}
+
+	w := &commandWaiter{priority: priority, ready: make(chan struct{})}
+	i := len(l.waiters)
+	for i > 0 && l.waiters[i-1].priority < priority {
+		i--
+	}
+	l.waiters = append(l.waiters[:i], append([]*commandWaiter{w}, l.waiters[i:]...)...)
+	l.metrics.setCommandQueueDepth(len(l.waiters))
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return l.release, nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	select {
+	case <-w.ready:
+		// the slot was handed over while the context was done
+		l.mu.Unlock()
+		l.release()
+		return nil, ctx.Err()
+	default:
+	}
+	for i, waiter := range l.waiters {
+		if waiter == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			break
+		}
+	}
+	l.metrics.setCommandQueueDepth(len(l.waiters))
+	l.mu.Unlock()
+
+	return nil, ctx.Err()
+}
+
+// Waiting returns the number of log commands waiting for a slot.
+func (l *CommandLimiter) Waiting() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.waiters)
+}
+
+// release hands the slot over to the first waiter, or frees it if nobody is waiting.
+func (l *CommandLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.waiters) == 0 {
+		l.running--
+		return
+	}
+
+	w := l.waiters[0]
+	l.waiters = l.waiters[1:]
+	l.metrics.setCommandQueueDepth(len(l.waiters))
+	close(w.ready)
+}
@@ -0,0 +1,112 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingRunner returns a runner that reports the predicate when it starts, and blocks until unblock is closed.
+func blockingRunner(predicate string, started chan<- string, unblock <-chan struct{}) runnerFunc {
+	return func(ctx context.Context) ([]byte, error) {
+		started <- predicate
+		select {
+		case <-unblock:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return []byte("{}\n"), nil
+	}
+}
+
+func TestCommandLimiter(t *testing.T) {
+	workdir := t.TempDir()
+	limiter := oslog_collector.NewCommandLimiter(1)
+
+	started := make(chan string, 10)
+	unblock := make(chan struct{})
+	newCollector := func(name string, priority int) *oslog_collector.OSLogCollector {
+		writePastPosition(t, filepath.Join(workdir, name+".pos"))
+		collector, err := oslog_collector.NewOSLogCollector(oslog_collector.OSLogCollectorConfig{
+			Name:         name,
+			Predicate:    "process == '" + name + "'",
+			OutputFile:   filepath.Join(workdir, name+".log"),
+			PositionFile: filepath.Join(workdir, name+".pos"),
+			Interval:     60,
+			Priority:     priority,
+		},
+			oslog_collector.WithCommandLimiter(limiter),
+			oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+				return blockingRunner(args[3], started, unblock)
+			}))
+		require.NoError(t, err)
+		t.Cleanup(func() { collector.Close() })
+		return collector
+	}
+
+	collectors := []*oslog_collector.OSLogCollector{
+		newCollector("first", 0),
+		newCollector("low", 0),
+		newCollector("high", 10),
+	}
+
+	errCh := make(chan error, len(collectors))
+	collect := func(c *oslog_collector.OSLogCollector) {
+		go func() { errCh <- c.CollectLogs(context.Background()) }()
+	}
+
+	// the first collector takes the only slot, and the others wait for it in the order of their priority
+	collect(collectors[0])
+	assert.Equal(t, "process == 'first'", <-started)
+
+	collect(collectors[1])
+	require.Eventually(t, func() bool { return limiter.Waiting() == 1 }, 5*time.Second, 10*time.Millisecond)
+	collect(collectors[2])
+	require.Eventually(t, func() bool { return limiter.Waiting() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	close(unblock)
+	assert.Equal(t, "process == 'high'", <-started)
+	assert.Equal(t, "process == 'low'", <-started)
+
+	for range collectors {
+		assert.NoError(t, <-errCh)
+	}
+	assert.Equal(t, 0, limiter.Waiting())
+
+	t.Run("when context is done while waiting then return error", func(t *testing.T) {
+		block := make(chan struct{})
+
+		writePastPosition(t, filepath.Join(workdir, "holder.pos"))
+		holder, err := oslog_collector.NewOSLogCollector(oslog_collector.OSLogCollectorConfig{
+			Name:         "holder",
+			Predicate:    "process == 'holder'",
+			OutputFile:   filepath.Join(workdir, "holder.log"),
+			PositionFile: filepath.Join(workdir, "holder.pos"),
+			Interval:     60,
+		},
+			oslog_collector.WithCommandLimiter(limiter),
+			oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+				return blockingRunner(args[3], started, block)
+			}))
+		require.NoError(t, err)
+		defer holder.Close()
+
+		holderDone := make(chan error)
+		go func() { holderDone <- holder.CollectLogs(context.Background()) }()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err = newCollector("waiter", 0).CollectLogs(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 0, limiter.Waiting())
+
+		close(block)
+		assert.NoError(t, <-holderDone)
+	})
+}
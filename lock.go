@@ -0,0 +1,154 @@
+package oslog_collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// errFileLocked is returned by lockFile when another process holds the lock of the file.
+var errFileLocked = errors.New("file is locked by another process")
+
+// fileLock is an advisory lock (flock) of a file. The lock is released by the OS when the process exits,
+// so a file left by a crashed process is not locked.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile opens the file, creating it if it does not exist, and takes an exclusive lock without blocking.
+func lockFile(path string, perm os.FileMode) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errFileLocked
+		}
+		return nil, fmt.Errorf("error locking %s: %v", path, err)
+	}
+
+	return &fileLock{file: file}, nil
+}
+
+// isFileLocked reports whether another process holds the lock of the file. It does not create the file.
+func isFileLocked(path string) (bool, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	lock, err := lockFile(path, 0o600)
+	if errors.Is(err, errFileLocked) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return false, lock.Unlock()
+}
+
+// Unlock releases the lock and closes the file.
+func (l *fileLock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+
+	if err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN); err != nil {
+		l.file.Close()
+		return fmt.Errorf("error unlocking %s: %v", l.file.Name(), err)
+	}
+
+	return l.file.Close()
+}
+
+// lockStateFile takes the lock of a position or state file, so that two agents running the same config
+// do not collect the same logs and overwrite the file of each other. The lock is taken on the separate lock file
+// returned by stateLockPath, because the file itself is replaced by writeFileAtomic.
+func lockStateFile(path string) (*fileLock, error) {
+	lock, err := lockFile(stateLockPath(path), 0o644)
+	if errors.Is(err, errFileLocked) {
+		return nil, fmt.Errorf("%s is locked by another oslog-collector process running the same config", path)
+	}
+	return lock, err
+}
+
+// isStateFileLocked reports whether another process holds the lock of a position or state file.
+func isStateFileLocked(path string) (bool, error) {
+	return isFileLocked(stateLockPath(path))
+}
+
+// stateLockPath returns the lock file of a position or state file, which is left after the lock is released.
+func stateLockPath(path string) string {
+	return path + ".lock"
+}
+
+// writeFileAtomic writes the data to a temporary file in the directory of the path, syncs it and renames it to the path,
+// so that the file has either the old or the new data even if the process crashes or the disk is full.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := writeAndSync(tmp, data, perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// sync the directory to persist the rename, which is not supported by some file systems
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// writeAndSync writes the data to the file, syncs it and closes it.
+func writeAndSync(file *os.File, data []byte, perm os.FileMode) error {
+	_, err := file.Write(data)
+	if err == nil {
+		err = file.Chmod(perm)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readPIDFile returns the PID recorded in the PID file, or 0 if it cannot be read.
+func readPIDFile(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	// signal 0 checks the existence of the process without sending a signal
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
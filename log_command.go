@@ -1,69 +1,243 @@
 package oslog_collector
 
-import "os/exec"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// logCommandKillDelay is the time to wait for the log command to exit after SIGTERM before sending SIGKILL
+const logCommandKillDelay = 5 * time.Second
 
 var (
 	_ LogCommandRunnerGenerator = NewLogCommandRunner
 	_ LogCommandRunner          = &logCommandRunner{}
 )
 
+var (
+	// exclusiveLogCommandOptions is a list of options of the log command that cannot be specified together
+	exclusiveLogCommandOptions = [][2]string{
+		{"--last", "--start"},
+	}
+	lastPattern = regexp.MustCompile(`^[0-9]+[mhd]?$`)
+)
+
 type LogCommandRunnerGenerator func(args []string) LogCommandRunner
 
 type LogCommandRunner interface {
-	RunLogCommand() ([]byte, error)
+	// RunLogCommand runs the log command and returns its output. The command is terminated when the context is done.
+	RunLogCommand(ctx context.Context) ([]byte, error)
 }
 
 type logCommandBuilder struct {
 	command []string
+	options map[string]int
+	last    string
+	color   string
 }
 
 type logCommandRunner struct {
-	cmd *exec.Cmd
+	args   []string
+	logger *slog.Logger
 }
 
 func NewLogCommandRunner(args []string) LogCommandRunner {
-	return &logCommandRunner{
-		cmd: exec.Command(args[0], args[1:]...),
+	return &logCommandRunner{args: args, logger: slog.Default()}
+}
+
+// newLogCommandRunner returns the generator of the runners that log the standard error with the logger
+// of the collector or the archive watcher.
+func newLogCommandRunner(logger *slog.Logger) LogCommandRunnerGenerator {
+	return func(args []string) LogCommandRunner {
+		return &logCommandRunner{args: args, logger: logger}
 	}
 }
 
-func (r *logCommandRunner) RunLogCommand() ([]byte, error) {
-	return r.cmd.CombinedOutput()
+// RunLogCommand runs the log command and returns its standard output. The standard error is added to the error,
+// or logged if the command succeeds, so that it is not parsed as logs. When the context is done, the command is sent
+// SIGTERM, and SIGKILL if it does not exit within logCommandKillDelay, and the error wraps the error of the context.
+func (r *logCommandRunner) RunLogCommand(ctx context.Context) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, r.args[0], r.args[1:]...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = logCommandKillDelay
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if stderr.Len() > 0 {
+			err = fmt.Errorf("%w, stderr: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		if ctx.Err() != nil {
+			return output, fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+		return output, err
+	}
+
+	if stderr.Len() > 0 {
+		r.logger.Warn("Log command wrote to stderr", "command", r.args[0], "stderr", string(bytes.TrimSpace(stderr.Bytes())))
+	}
+	return output, nil
 }
 
 func NewLogCommandBuilder() *logCommandBuilder {
 	return &logCommandBuilder{
 		command: []string{"log", "show"},
+		options: map[string]int{},
 	}
 }
 
-func (b *logCommandBuilder) WithPredicate(predicate string) *logCommandBuilder {
-	b.command = append(b.command, "--predicate", predicate)
+func (b *logCommandBuilder) add(option string, values ...string) *logCommandBuilder {
+	b.options[option]++
+	b.command = append(b.command, option)
+	b.command = append(b.command, values...)
 	return b
 }
 
+func (b *logCommandBuilder) WithPredicate(predicate string) *logCommandBuilder {
+	return b.add("--predicate", predicate)
+}
+
 func (b *logCommandBuilder) WithStartTime(startTime string) *logCommandBuilder {
-	b.command = append(b.command, "--start", startTime)
-	return b
+	return b.add("--start", startTime)
 }
 
 func (b *logCommandBuilder) WithEndTime(endTime string) *logCommandBuilder {
-	b.command = append(b.command, "--end", endTime)
-	return b
+	return b.add("--end", endTime)
+}
+
+// WithLast adds the --last option, which shows the logs of the last num[m|h|d] (e.g. 30m).
+func (b *logCommandBuilder) WithLast(last string) *logCommandBuilder {
+	if last == "" {
+		return b
+	}
+	b.last = last
+	return b.add("--last", last)
+}
+
+// WithArchive adds the --archive option, which reads the logs from a .logarchive bundle instead of the live system.
+func (b *logCommandBuilder) WithArchive(archivePath string) *logCommandBuilder {
+	if archivePath == "" {
+		return b
+	}
+	return b.add("--archive", archivePath)
 }
 
 func (b *logCommandBuilder) WithStyle(style string) *logCommandBuilder {
-	b.command = append(b.command, "--style", style)
-	return b
+	return b.add("--style", style)
 }
 
 func (b *logCommandBuilder) WithInfoLevel(enable bool) *logCommandBuilder {
 	if enable {
-		b.command = append(b.command, "--info")
+		b.add("--info")
+	}
+	return b
+}
+
+func (b *logCommandBuilder) WithDebugLevel(enable bool) *logCommandBuilder {
+	if enable {
+		b.add("--debug")
+	}
+	return b
+}
+
+func (b *logCommandBuilder) WithSignpost(enable bool) *logCommandBuilder {
+	if enable {
+		b.add("--signpost")
 	}
 	return b
 }
 
+// WithProcess adds the --process option, which filters the logs by a process ID or name.
+func (b *logCommandBuilder) WithProcess(process string) *logCommandBuilder {
+	if process == "" {
+		return b
+	}
+	return b.add("--process", process)
+}
+
+func (b *logCommandBuilder) WithSource(enable bool) *logCommandBuilder {
+	if enable {
+		b.add("--source")
+	}
+	return b
+}
+
+// WithTimezone adds the --timezone option, which is "local" or a timezone name such as "UTC".
+func (b *logCommandBuilder) WithTimezone(timezone string) *logCommandBuilder {
+	if timezone == "" {
+		return b
+	}
+	return b.add("--timezone", timezone)
+}
+
+func (b *logCommandBuilder) WithNoBacktrace(enable bool) *logCommandBuilder {
+	if enable {
+		b.add("--no-backtrace")
+	}
+	return b
+}
+
+func (b *logCommandBuilder) WithMachContinuousTime(enable bool) *logCommandBuilder {
+	if enable {
+		b.add("--mach-continuous-time")
+	}
+	return b
+}
+
+// WithColor adds the --color option. Only "none" is accepted, because the escape sequences of the other colors
+// break the ndjson output parsed by the sinks, outputs and processors.
+func (b *logCommandBuilder) WithColor(color string) *logCommandBuilder {
+	if color == "" {
+		return b
+	}
+	b.color = color
+	return b.add("--color", color)
+}
+
+// Validate returns an error if the options added to the builder cannot be passed to the log command together.
+func (b *logCommandBuilder) Validate() error {
+	for _, option := range sortedKeys(b.options) {
+		if b.options[option] > 1 {
+			return fmt.Errorf("%s is specified more than once", option)
+		}
+	}
+
+	for _, pair := range exclusiveLogCommandOptions {
+		if b.options[pair[0]] > 0 && b.options[pair[1]] > 0 {
+			return fmt.Errorf("%s and %s are mutually exclusive", pair[0], pair[1])
+		}
+	}
+
+	if b.last != "" && !lastPattern.MatchString(b.last) {
+		return fmt.Errorf("invalid --last value: %q, must be num[m|h|d]", b.last)
+	}
+
+	if b.color != "" && b.color != "none" {
+		return fmt.Errorf("invalid --color value: %q, must be none", b.color)
+	}
+
+	return nil
+}
+
 func (b *logCommandBuilder) Build() []string {
 	return b.command
 }
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
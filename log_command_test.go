@@ -1,7 +1,9 @@
 package oslog_collector_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	oslog_collector "github.com/mrtc0/oslog-collector"
 	"github.com/stretchr/testify/assert"
@@ -10,6 +12,72 @@ import (
 func TestLogCommandRunner_RunLogCommand(t *testing.T) {
 	t.Parallel()
 
+	testCases := map[string]struct {
+		args       []string
+		maxElapsed time.Duration
+	}{
+		"when context is canceled then terminate the command": {
+			args:       []string{"sleep", "60"},
+			maxElapsed: 2 * time.Second,
+		},
+		"when command ignores SIGTERM then kill it": {
+			args:       []string{"sh", "-c", "trap '' TERM; exec sleep 60"},
+			maxElapsed: 10 * time.Second,
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			started := time.Now()
+			_, err := oslog_collector.NewLogCommandRunner(tt.args).RunLogCommand(ctx)
+
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Less(t, time.Since(started), tt.maxElapsed)
+		})
+	}
+}
+
+func TestLogCommandRunner_RunLogCommand_Stderr(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		args         []string
+		expectOutput string
+		expectErr    string
+	}{
+		"when command succeeds then return stdout only": {
+			args:         []string{"sh", "-c", "echo out; echo warning >&2"},
+			expectOutput: "out\n",
+		},
+		"when command fails then add stderr to the error": {
+			args:         []string{"sh", "-c", "echo out; echo failure >&2; exit 64"},
+			expectOutput: "out\n",
+			expectErr:    "exit status 64, stderr: failure",
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			output, err := oslog_collector.NewLogCommandRunner(tt.args).RunLogCommand(context.Background())
+			if tt.expectErr != "" {
+				assert.EqualError(t, err, tt.expectErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectOutput, string(output))
+		})
+	}
 }
 
 func TestNewLogCommandBuilder(t *testing.T) {
@@ -31,6 +99,39 @@ func TestNewLogCommandBuilder(t *testing.T) {
 			buildResult:   oslog_collector.NewLogCommandBuilder().WithPredicate("subsystem == 'com.apple.mdns'").WithInfoLevel(true).Build(),
 			expectCommand: []string{"log", "show", "--predicate", "subsystem == 'com.apple.mdns'", "--info"},
 		},
+		"with debug level and signpost": {
+			buildResult:   oslog_collector.NewLogCommandBuilder().WithDebugLevel(true).WithSignpost(true).Build(),
+			expectCommand: []string{"log", "show", "--debug", "--signpost"},
+		},
+		"with disabled flags": {
+			buildResult: oslog_collector.NewLogCommandBuilder().
+				WithInfoLevel(false).WithDebugLevel(false).WithSignpost(false).WithSource(false).
+				WithNoBacktrace(false).WithMachContinuousTime(false).
+				Build(),
+			expectCommand: []string{"log", "show"},
+		},
+		"with empty values": {
+			buildResult: oslog_collector.NewLogCommandBuilder().
+				WithProcess("").WithTimezone("").WithLast("").WithArchive("").WithColor("").
+				Build(),
+			expectCommand: []string{"log", "show"},
+		},
+		"with process and source": {
+			buildResult:   oslog_collector.NewLogCommandBuilder().WithProcess("mDNSResponder").WithSource(true).Build(),
+			expectCommand: []string{"log", "show", "--process", "mDNSResponder", "--source"},
+		},
+		"with timezone, no backtrace and mach continuous time": {
+			buildResult:   oslog_collector.NewLogCommandBuilder().WithTimezone("UTC").WithNoBacktrace(true).WithMachContinuousTime(true).Build(),
+			expectCommand: []string{"log", "show", "--timezone", "UTC", "--no-backtrace", "--mach-continuous-time"},
+		},
+		"with last": {
+			buildResult:   oslog_collector.NewLogCommandBuilder().WithLast("1h").WithEndTime("2025-01-29 00:00:00").Build(),
+			expectCommand: []string{"log", "show", "--last", "1h", "--end", "2025-01-29 00:00:00"},
+		},
+		"with archive and color": {
+			buildResult:   oslog_collector.NewLogCommandBuilder().WithArchive("/tmp/system.logarchive").WithColor("none").Build(),
+			expectCommand: []string{"log", "show", "--archive", "/tmp/system.logarchive", "--color", "none"},
+		},
 	}
 
 	for name, tt := range testCases {
@@ -43,3 +144,80 @@ func TestNewLogCommandBuilder(t *testing.T) {
 		})
 	}
 }
+
+func TestLogCommandBuilder_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		builder          func() interface{ Validate() error }
+		expectErr        bool
+		expectErrMessage string
+	}{
+		"when options are valid": {
+			builder: func() interface{ Validate() error } {
+				return oslog_collector.NewLogCommandBuilder().
+					WithPredicate("subsystem == 'com.apple.mdns'").WithStartTime("2025-01-29 00:00:00").WithEndTime("2025-01-29 00:01:00").
+					WithStyle("ndjson").WithDebugLevel(true).WithColor("none")
+			},
+			expectErr: false,
+		},
+		"when last is combined with end": {
+			builder: func() interface{ Validate() error } {
+				return oslog_collector.NewLogCommandBuilder().WithLast("30m").WithEndTime("2025-01-29 00:01:00")
+			},
+			expectErr: false,
+		},
+		"when last is combined with start": {
+			builder: func() interface{ Validate() error } {
+				return oslog_collector.NewLogCommandBuilder().WithLast("30m").WithStartTime("2025-01-29 00:00:00")
+			},
+			expectErr:        true,
+			expectErrMessage: "--last and --start are mutually exclusive",
+		},
+		"when an option is specified more than once": {
+			builder: func() interface{ Validate() error } {
+				return oslog_collector.NewLogCommandBuilder().WithTimezone("UTC").WithTimezone("local")
+			},
+			expectErr:        true,
+			expectErrMessage: "--timezone is specified more than once",
+		},
+		"when last is invalid": {
+			builder: func() interface{ Validate() error } {
+				return oslog_collector.NewLogCommandBuilder().WithLast("1 hour")
+			},
+			expectErr:        true,
+			expectErrMessage: "invalid --last value",
+		},
+		"when color is invalid": {
+			builder: func() interface{ Validate() error } {
+				return oslog_collector.NewLogCommandBuilder().WithColor("never")
+			},
+			expectErr:        true,
+			expectErrMessage: "invalid --color value",
+		},
+		"when color puts escape sequences into the output": {
+			builder: func() interface{ Validate() error } {
+				return oslog_collector.NewLogCommandBuilder().WithStyle("ndjson").WithColor("always")
+			},
+			expectErr:        true,
+			expectErrMessage: `invalid --color value: "always", must be none`,
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.builder().Validate()
+			if tt.expectErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMessage)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	logOutputStderr = "stderr"
+
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	defaultLogMaxBackups = 5
+)
+
+var (
+	logLevels  = map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError}
+	logFormats = []string{logFormatText, logFormatJSON}
+)
+
+// LogFile is the output of the logger of the agent itself.
+type LogFile interface {
+	// Reopen opens the log file again, e.g. after it is moved by newsyslog. It does nothing for stderr.
+	Reopen() error
+	Close() error
+}
+
+// NewLogger creates the logger of the agent itself from the config. If config is nil, it logs at the info level
+// in the text format to stderr. The returned log file must be closed when the logger is no longer used.
+func NewLogger(config *LogConfig) (*slog.Logger, LogFile, error) {
+	if config == nil {
+		config = &LogConfig{}
+	}
+	if err := validateLogConfig(config); err != nil {
+		return nil, nil, err
+	}
+
+	var w logWriter = nopLogWriter{os.Stderr}
+	if config.Output != "" && config.Output != logOutputStderr {
+		maxBackups := config.MaxBackups
+		if maxBackups == 0 {
+			maxBackups = defaultLogMaxBackups
+		}
+
+		rw, err := newRotatingWriter(config.Output, int64(config.MaxSizeMB)*1024*1024, maxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		w = rw
+	}
+
+	options := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if config.Level != "" {
+		options.Level = logLevels[strings.ToLower(config.Level)]
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if config.Format == logFormatJSON {
+		handler = slog.NewJSONHandler(w, options)
+	}
+
+	return slog.New(handler), w, nil
+}
+
+func validateLogConfig(config *LogConfig) error {
+	if _, ok := logLevels[strings.ToLower(config.Level)]; config.Level != "" && !ok {
+		return fmt.Errorf("log: invalid level %q, must be one of debug, info, warn, error", config.Level)
+	}
+	if config.Format != "" && !slices.Contains(logFormats, config.Format) {
+		return fmt.Errorf("log: invalid format %q, must be one of %s", config.Format, strings.Join(logFormats, ", "))
+	}
+	if config.MaxSizeMB < 0 {
+		return fmt.Errorf("log: max_size_mb must not be negative")
+	}
+	if config.MaxBackups < 0 {
+		return fmt.Errorf("log: max_backups must not be negative")
+	}
+	return nil
+}
+
+type logWriter interface {
+	io.Writer
+	LogFile
+}
+
+// nopLogWriter writes to stderr, which is neither reopened nor closed.
+type nopLogWriter struct {
+	io.Writer
+}
+
+func (nopLogWriter) Reopen() error {
+	return nil
+}
+
+func (nopLogWriter) Close() error {
+	return nil
+}
+
+// rotatingWriter appends to a file and rotates it to path.1, path.2, ... when it exceeds maxSize bytes.
+// The file is not rotated if maxSize is 0. If the rotation fails, it keeps writing to the current file, and tries again
+// after another maxSize bytes.
+type rotatingWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newRotatingWriter(path string, maxSize int64, maxBackups int) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	file, size, err := w.open()
+	if err != nil {
+		return nil, err
+	}
+	w.file, w.size = file, size
+	return w, nil
+}
+
+func (w *rotatingWriter) open() (*os.File, int64, error) {
+	file, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening log file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("error opening log file: %v", err)
+	}
+
+	return file, info.Size(), nil
+}
+
+// Write writes to the current file even if the rotation fails, and returns the error of the rotation.
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var rotateErr error
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if rotateErr = w.rotate(); rotateErr != nil {
+			w.size = 0
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+// rotate shifts the backups, dropping the oldest one, and starts a new file. The current file is closed only after
+// the new one is opened. The caller must hold w.mu.
+func (w *rotatingWriter) rotate() error {
+	os.Remove(fmt.Sprintf("%s.%d", w.path, w.maxBackups))
+	for i := w.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return fmt.Errorf("error rotating log file: %v", err)
+	}
+
+	if err := w.swap(); err != nil {
+		// move the current file back, so that it is not shifted as a backup on the next rotation
+		os.Rename(w.path+".1", w.path)
+		return err
+	}
+	return nil
+}
+
+// swap opens the file at the path and closes the current one, or keeps the current one if the file cannot be opened.
+// The caller must hold w.mu.
+func (w *rotatingWriter) swap() error {
+	file, size, err := w.open()
+	if err != nil {
+		return err
+	}
+
+	old := w.file
+	w.file, w.size = file, size
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("error closing log file: %v", err)
+	}
+	return nil
+}
+
+// Reopen opens the file at the path again, so that the logs are written to a new file after the file is moved.
+func (w *rotatingWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.swap()
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
@@ -0,0 +1,111 @@
+package oslog_collector_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogger(t *testing.T) {
+	t.Run("when output is a file then write in the format at the level", func(t *testing.T) {
+		logFile := filepath.Join(t.TempDir(), "oslog-collector.log")
+
+		logger, closer, err := oslog_collector.NewLogger(&oslog_collector.LogConfig{Level: "warn", Format: "json", Output: logFile})
+		require.NoError(t, err)
+
+		logger.Info("not logged")
+		logger.Warn("logged", "collector_name", "foo")
+		require.NoError(t, closer.Close())
+
+		data, err := os.ReadFile(logFile)
+		require.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		require.Len(t, lines, 1)
+
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+		assert.Equal(t, "WARN", record["level"])
+		assert.Equal(t, "logged", record["msg"])
+		assert.Equal(t, "foo", record["collector_name"])
+	})
+
+	t.Run("when log file exceeds max size then rotate it", func(t *testing.T) {
+		logFile := filepath.Join(t.TempDir(), "oslog-collector.log")
+
+		logger, closer, err := oslog_collector.NewLogger(&oslog_collector.LogConfig{Output: logFile, MaxSizeMB: 1, MaxBackups: 2})
+		require.NoError(t, err)
+		defer closer.Close()
+
+		// each record is about 100KB, so that the file is rotated every 10 records
+		message := strings.Repeat("x", 100*1024)
+		for i := 0; i < 35; i++ {
+			logger.Info(message)
+		}
+
+		for _, name := range []string{"oslog-collector.log", "oslog-collector.log.1", "oslog-collector.log.2"} {
+			info, err := os.Stat(filepath.Join(filepath.Dir(logFile), name))
+			require.NoError(t, err, name)
+			assert.LessOrEqual(t, info.Size(), int64(1024*1024), name)
+		}
+
+		_, err = os.Stat(logFile + ".3")
+		assert.True(t, os.IsNotExist(err), "backups more than max_backups are removed")
+	})
+
+	t.Run("when rotation fails then keep writing to the current file", func(t *testing.T) {
+		logFile := filepath.Join(t.TempDir(), "oslog-collector.log")
+		// a directory that is not empty cannot be removed or replaced by the rotation
+		require.NoError(t, os.MkdirAll(filepath.Join(logFile+".1", "keep"), 0755))
+
+		logger, logWriter, err := oslog_collector.NewLogger(&oslog_collector.LogConfig{Output: logFile, MaxSizeMB: 1, MaxBackups: 1})
+		require.NoError(t, err)
+		defer logWriter.Close()
+
+		message := strings.Repeat("x", 100*1024)
+		for i := 0; i < 15; i++ {
+			logger.Info(message)
+		}
+
+		data, err := os.ReadFile(logFile)
+		require.NoError(t, err)
+		assert.Equal(t, 15, strings.Count(string(data), "\n"))
+	})
+
+	t.Run("when log file is reopened then write to a new file", func(t *testing.T) {
+		logFile := filepath.Join(t.TempDir(), "oslog-collector.log")
+
+		logger, logWriter, err := oslog_collector.NewLogger(&oslog_collector.LogConfig{Output: logFile})
+		require.NoError(t, err)
+		defer logWriter.Close()
+
+		logger.Info("before")
+		require.NoError(t, os.Rename(logFile, logFile+".0"))
+		logger.Info("moved")
+		require.NoError(t, logWriter.Reopen())
+		logger.Info("after")
+
+		moved, err := os.ReadFile(logFile + ".0")
+		require.NoError(t, err)
+		assert.Contains(t, string(moved), "moved")
+
+		data, err := os.ReadFile(logFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "after")
+		assert.NotContains(t, string(data), "before")
+	})
+
+	t.Run("when config is invalid then return error", func(t *testing.T) {
+		_, _, err := oslog_collector.NewLogger(&oslog_collector.LogConfig{Level: "verbose"})
+		assert.EqualError(t, err, `log: invalid level "verbose", must be one of debug, info, warn, error`)
+
+		_, _, err = oslog_collector.NewLogger(&oslog_collector.LogConfig{Format: "logfmt"})
+		assert.EqualError(t, err, `log: invalid format "logfmt", must be one of text, json`)
+	})
+}
@@ -0,0 +1,397 @@
+package oslog_collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// logCommandDurationBuckets are the upper bounds in seconds of the buckets of the log command duration histogram.
+var logCommandDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var metricLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metrics records the health of the collectors and archive watchers and exposes it in the Prometheus text format.
+// All methods can be called on a nil *Metrics, which records nothing.
+type Metrics struct {
+	mu                sync.Mutex
+	collectors        map[string]*collectorMetrics
+	watchers          map[string]*archiveWatcherMetrics
+	commandQueueDepth int
+}
+
+type collectorMetrics struct {
+	runs          uint64
+	failures      uint64
+	timeouts      uint64
+	retries       uint64
+	outputErrors  uint64
+	bytesWritten  uint64
+	entries       uint64
+	duration      histogram
+	lastTimestamp time.Time
+	degraded      bool
+}
+
+type archiveWatcherMetrics struct {
+	processed  uint64
+	failures   uint64
+	queueDepth int
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(logCommandDurationBuckets))
+	}
+	for i, bound := range logCommandDurationBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		collectors: map[string]*collectorMetrics{},
+		watchers:   map[string]*archiveWatcherMetrics{},
+	}
+}
+
+// collector returns the metrics of the collector, creating them if needed. The caller must hold m.mu.
+func (m *Metrics) collector(name string) *collectorMetrics {
+	c, ok := m.collectors[name]
+	if !ok {
+		c = &collectorMetrics{}
+		m.collectors[name] = c
+	}
+	return c
+}
+
+// watcher returns the metrics of the archive watcher, creating them if needed. The caller must hold m.mu.
+func (m *Metrics) watcher(name string) *archiveWatcherMetrics {
+	w, ok := m.watchers[name]
+	if !ok {
+		w = &archiveWatcherMetrics{}
+		m.watchers[name] = w
+	}
+	return w
+}
+
+// observeRun records a collection of the collector and whether it failed.
+func (m *Metrics) observeRun(name string, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.collector(name)
+	c.runs++
+	if err != nil {
+		c.failures++
+	}
+}
+
+// observeLogCommand records the duration of a log command run by the collector.
+func (m *Metrics) observeLogCommand(name string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.collector(name).duration.observe(duration.Seconds())
+}
+
+// observeTimeout records a log command of the collector killed by the command timeout.
+func (m *Metrics) observeTimeout(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.collector(name).timeouts++
+}
+
+// observeRetry records a retry of a failed collection of the collector.
+func (m *Metrics) observeRetry(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.collector(name).retries++
+}
+
+// setDegraded records whether the circuit breaker of the collector is open.
+func (m *Metrics) setDegraded(name string, degraded bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.collector(name).degraded = degraded
+}
+
+// observeOutput records the bytes and entries written to the output file by the collector, or the error writing them.
+func (m *Metrics) observeOutput(name string, size, entries int, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.collector(name)
+	if err != nil {
+		c.outputErrors++
+		return
+	}
+	c.bytesWritten += uint64(size)
+	c.entries += uint64(entries)
+}
+
+// setPosition records the LastTimestamp of the collector, from which the lag is calculated.
+func (m *Metrics) setPosition(name, lastTimestamp string) {
+	if m == nil {
+		return
+	}
+
+	t, err := parseLogTimestamp(lastTimestamp)
+	if err != nil {
+		// the position is not known yet when the first window starts from --last
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.collector(name).lastTimestamp = t
+}
+
+// observeArchive records a bundle processed by the archive watcher and whether it failed.
+func (m *Metrics) observeArchive(name string, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := m.watcher(name)
+	w.processed++
+	if err != nil {
+		w.failures++
+	}
+}
+
+// setArchiveQueueDepth records the number of bundles waiting to be processed by the archive watcher.
+func (m *Metrics) setArchiveQueueDepth(name string, depth int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.watcher(name).queueDepth = depth
+}
+
+// setCommandQueueDepth records the number of log commands waiting for max_concurrent_commands.
+func (m *Metrics) setCommandQueueDepth(depth int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.commandQueueDepth = depth
+}
+
+// removeCollector removes the metrics of a collector removed from the config.
+func (m *Metrics) removeCollector(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.collectors, name)
+}
+
+// removeArchiveWatcher removes the metrics of an archive watcher removed from the config.
+func (m *Metrics) removeArchiveWatcher(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.watchers, name)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	if m != nil {
+		m.mu.Lock()
+		m.writeCollectorMetrics(&buf)
+		m.writeArchiveWatcherMetrics(&buf)
+		writeMetricHeader(&buf, "oslog_collector_command_queue_depth", "gauge", "Number of log commands waiting for max_concurrent_commands.")
+		fmt.Fprintf(&buf, "oslog_collector_command_queue_depth %d\n", m.commandQueueDepth)
+		m.mu.Unlock()
+	}
+
+	if rss, ok := residentMemoryBytes(); ok {
+		writeMetricHeader(&buf, "oslog_collector_process_resident_memory_bytes", "gauge", "Resident set size of the process in bytes.")
+		fmt.Fprintf(&buf, "oslog_collector_process_resident_memory_bytes %d\n", rss)
+	}
+
+	return buf.WriteTo(w)
+}
+
+// writeCollectorMetrics writes the metrics of the collectors. The caller must hold m.mu.
+func (m *Metrics) writeCollectorMetrics(buf *bytes.Buffer) {
+	names := sortedKeys(m.collectors)
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(*collectorMetrics) uint64
+	}{
+		{"oslog_collector_runs_total", "Number of collections.", func(c *collectorMetrics) uint64 { return c.runs }},
+		{"oslog_collector_failures_total", "Number of failed collections.", func(c *collectorMetrics) uint64 { return c.failures }},
+		{"oslog_collector_command_timeouts_total", "Number of log commands killed by the command timeout.", func(c *collectorMetrics) uint64 { return c.timeouts }},
+		{"oslog_collector_retries_total", "Number of retries of failed collections.", func(c *collectorMetrics) uint64 { return c.retries }},
+		{"oslog_collector_output_errors_total", "Number of errors writing to the output file.", func(c *collectorMetrics) uint64 { return c.outputErrors }},
+		{"oslog_collector_written_bytes_total", "Number of bytes written to the output file.", func(c *collectorMetrics) uint64 { return c.bytesWritten }},
+		{"oslog_collector_written_entries_total", "Number of log entries written to the output file.", func(c *collectorMetrics) uint64 { return c.entries }},
+	}
+
+	for _, counter := range counters {
+		writeMetricHeader(buf, counter.name, "counter", counter.help)
+		for _, name := range names {
+			fmt.Fprintf(buf, "%s{collector=\"%s\"} %d\n", counter.name, escapeLabelValue(name), counter.value(m.collectors[name]))
+		}
+	}
+
+	writeMetricHeader(buf, "oslog_collector_log_command_duration_seconds", "histogram", "Duration of the log command.")
+	for _, name := range names {
+		h := m.collectors[name].duration
+		label := escapeLabelValue(name)
+		for i, bound := range logCommandDurationBuckets {
+			var count uint64
+			if h.counts != nil {
+				count = h.counts[i]
+			}
+			fmt.Fprintf(buf, "oslog_collector_log_command_duration_seconds_bucket{collector=\"%s\",le=\"%g\"} %d\n", label, bound, count)
+		}
+		fmt.Fprintf(buf, "oslog_collector_log_command_duration_seconds_bucket{collector=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(buf, "oslog_collector_log_command_duration_seconds_sum{collector=\"%s\"} %g\n", label, h.sum)
+		fmt.Fprintf(buf, "oslog_collector_log_command_duration_seconds_count{collector=\"%s\"} %d\n", label, h.count)
+	}
+
+	writeMetricHeader(buf, "oslog_collector_degraded", "gauge", "Whether the circuit breaker of the collector is open (1) or not (0).")
+	for _, name := range names {
+		var degraded int
+		if m.collectors[name].degraded {
+			degraded = 1
+		}
+		fmt.Fprintf(buf, "oslog_collector_degraded{collector=\"%s\"} %d\n", escapeLabelValue(name), degraded)
+	}
+
+	now := flextime.Now()
+	writeMetricHeader(buf, "oslog_collector_lag_seconds", "gauge", "Seconds between now and the position of the collector.")
+	for _, name := range names {
+		c := m.collectors[name]
+		if c.lastTimestamp.IsZero() {
+			continue
+		}
+		fmt.Fprintf(buf, "oslog_collector_lag_seconds{collector=\"%s\"} %g\n", escapeLabelValue(name), now.Sub(c.lastTimestamp).Seconds())
+	}
+}
+
+// writeArchiveWatcherMetrics writes the metrics of the archive watchers. The caller must hold m.mu.
+func (m *Metrics) writeArchiveWatcherMetrics(buf *bytes.Buffer) {
+	names := sortedKeys(m.watchers)
+
+	writeMetricHeader(buf, "oslog_collector_archives_processed_total", "counter", "Number of processed log archives.")
+	for _, name := range names {
+		fmt.Fprintf(buf, "oslog_collector_archives_processed_total{watcher=\"%s\"} %d\n", escapeLabelValue(name), m.watchers[name].processed)
+	}
+
+	writeMetricHeader(buf, "oslog_collector_archive_failures_total", "counter", "Number of log archives that failed to be processed.")
+	for _, name := range names {
+		fmt.Fprintf(buf, "oslog_collector_archive_failures_total{watcher=\"%s\"} %d\n", escapeLabelValue(name), m.watchers[name].failures)
+	}
+
+	writeMetricHeader(buf, "oslog_collector_archive_queue_depth", "gauge", "Number of log archives waiting to be processed.")
+	for _, name := range names {
+		fmt.Fprintf(buf, "oslog_collector_archive_queue_depth{watcher=\"%s\"} %d\n", escapeLabelValue(name), m.watchers[name].queueDepth)
+	}
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func escapeLabelValue(value string) string {
+	return metricLabelValueReplacer.Replace(value)
+}
+
+// residentMemoryBytes returns the current resident set size of the process. It is read from /proc on Linux, and
+// from ps(1) on macOS, where proc_pidinfo and task_info are not available without cgo.
+func residentMemoryBytes() (int64, bool) {
+	if runtime.GOOS == "linux" {
+		statm, err := os.ReadFile("/proc/self/statm")
+		if err != nil {
+			return 0, false
+		}
+		// the second field is the number of resident pages
+		fields := strings.Fields(string(statm))
+		if len(fields) < 2 {
+			return 0, false
+		}
+		pages, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return pages * int64(os.Getpagesize()), true
+	}
+
+	// the rss column of ps is in kilobytes
+	output, err := exec.Command("ps", "-o", "rss=", "-p", strconv.Itoa(os.Getpid())).Output()
+	if err != nil {
+		return 0, false
+	}
+	kilobytes, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return kilobytes * 1024, true
+}
@@ -0,0 +1,102 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ndjsonOutput is the output of two entries followed by the summary line.
+const ndjsonOutput = "{\"eventMessage\":\"foo\"}\n{\"eventMessage\":\"bar\"}\n{\"count\":2,\"finished\":1}\n"
+
+func TestMetrics(t *testing.T) {
+	flextime.Fix(time.Date(2025, 1, 29, 0, 0, 0, 0, time.Local))
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	metrics := oslog_collector.NewMetrics()
+
+	newCollector := func(name string, runner oslog_collector.LogCommandRunner) *oslog_collector.OSLogCollector {
+		writePastPosition(t, filepath.Join(workdir, name+".pos"))
+		collector, err := oslog_collector.NewOSLogCollector(oslog_collector.OSLogCollectorConfig{
+			Name:         name,
+			Predicate:    "process == 'test'",
+			OutputFile:   filepath.Join(workdir, name+".log"),
+			PositionFile: filepath.Join(workdir, name+".pos"),
+			Interval:     60,
+		},
+			oslog_collector.WithMetrics(metrics),
+			oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner { return runner }),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { collector.Close() })
+		return collector
+	}
+
+	succeeding := newCollector("foo", outputRunner(ndjsonOutput))
+	failing := newCollector("bar", failingRunner)
+
+	require.NoError(t, succeeding.CollectLogs(context.Background()))
+	flextime.Fix(flextime.Now().Add(30 * time.Second))
+	require.NoError(t, succeeding.CollectLogs(context.Background()))
+	require.Error(t, failing.CollectLogs(context.Background()))
+
+	// the logs of a collector without output_file are only delivered to the sink, and are not counted as written
+	batches := make(chan *oslog_collector.LogBatch, 1)
+	writePastPosition(t, filepath.Join(workdir, "baz.pos"))
+	sinkOnly, err := oslog_collector.NewOSLogCollector(oslog_collector.OSLogCollectorConfig{
+		Name:         "baz",
+		Predicate:    "process == 'test'",
+		PositionFile: filepath.Join(workdir, "baz.pos"),
+		Interval:     60,
+	},
+		oslog_collector.WithMetrics(metrics),
+		oslog_collector.WithSinkChannel(batches),
+		oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner { return outputRunner(ndjsonOutput) }),
+	)
+	require.NoError(t, err)
+	defer sinkOnly.Close()
+	go func() { (<-batches).Ack(nil) }()
+	require.NoError(t, sinkOnly.CollectLogs(context.Background()))
+
+	flextime.Fix(flextime.Now().Add(90 * time.Second))
+
+	agent := &oslog_collector.Agent{Metrics: metrics}
+	response := httptest.NewRecorder()
+	agent.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/metrics", nil))
+
+	require.Equal(t, 200, response.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", response.Header().Get("Content-Type"))
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	for _, line := range []string{
+		"# TYPE oslog_collector_runs_total counter",
+		`oslog_collector_runs_total{collector="foo"} 2`,
+		`oslog_collector_runs_total{collector="bar"} 1`,
+		`oslog_collector_failures_total{collector="foo"} 0`,
+		`oslog_collector_failures_total{collector="bar"} 1`,
+		`oslog_collector_written_bytes_total{collector="foo"} 142`,
+		`oslog_collector_written_entries_total{collector="foo"} 4`,
+		`oslog_collector_output_errors_total{collector="foo"} 0`,
+		`oslog_collector_written_bytes_total{collector="baz"} 0`,
+		`oslog_collector_written_entries_total{collector="baz"} 0`,
+		"# TYPE oslog_collector_log_command_duration_seconds histogram",
+		`oslog_collector_log_command_duration_seconds_bucket{collector="foo",le="+Inf"} 2`,
+		`oslog_collector_log_command_duration_seconds_count{collector="bar"} 1`,
+		`oslog_collector_lag_seconds{collector="foo"} 90`,
+		`oslog_collector_lag_seconds{collector="bar"} 180`,
+		"# TYPE oslog_collector_process_resident_memory_bytes gauge",
+	} {
+		assert.Contains(t, string(body), line+"\n")
+	}
+}
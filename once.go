@@ -0,0 +1,41 @@
+package oslog_collector
+
+import "context"
+
+// CollectResult is the result of a single collection of a collector.
+type CollectResult struct {
+	Name string
+	Err  error
+}
+
+// RunOnce runs a single collection of the collectors with the names, or all collectors if no names are given,
+// and updates their position files. Unlike StartLogCollectors, it returns after every collector has run once.
+// If the context is done, the running log command is terminated and the remaining collections fail.
+func RunOnce(ctx context.Context, config *Config, names []string, opts ...OSLogCollectorOption) ([]CollectResult, error) {
+	collectors, err := selectCollectors(config, names)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]CollectResult, 0, len(collectors))
+	for _, c := range collectors {
+		results = append(results, CollectResult{Name: c.Name, Err: collectOnce(ctx, c, opts...)})
+	}
+
+	return results, nil
+}
+
+func collectOnce(ctx context.Context, config OSLogCollectorConfig, opts ...OSLogCollectorOption) error {
+	collector, err := NewOSLogCollector(config, opts...)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := collector.Close(); err != nil {
+			collector.logger.Error("Error closing collector", "error", err)
+		}
+	}()
+
+	return collector.CollectLogs(ctx)
+}
@@ -0,0 +1,101 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunOnce(t *testing.T) {
+	flextime.Set(time.Date(2025, 1, 29, 0, 0, 0, 0, time.Now().Location()))
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+
+	collectorConfig := func(name string) oslog_collector.OSLogCollectorConfig {
+		return oslog_collector.OSLogCollectorConfig{
+			Name:         name,
+			Predicate:    fmt.Sprintf("process == '%s'", name),
+			OutputFile:   filepath.Join(workdir, name+".log"),
+			PositionFile: filepath.Join(workdir, name+".pos"),
+			Interval:     60,
+		}
+	}
+
+	cfg := &oslog_collector.Config{
+		Collectors: []oslog_collector.OSLogCollectorConfig{
+			collectorConfig("foo"),
+			collectorConfig("bar"),
+			collectorConfig("baz"),
+		},
+	}
+
+	dummyRunnerGeenerator := func(args []string) oslog_collector.LogCommandRunner {
+		if args[3] == "process == 'bar'" {
+			return failingRunner
+		}
+		return testLogRunner
+	}
+
+	testCases := map[string]struct {
+		names            []string
+		expectResults    map[string]bool
+		expectErr        bool
+		expectErrMessage string
+	}{
+		"when no names are given then run all collectors": {
+			expectResults: map[string]bool{"foo": true, "bar": false, "baz": true},
+		},
+		"when names are given then run the selected collectors": {
+			names:         []string{"baz"},
+			expectResults: map[string]bool{"baz": true},
+		},
+		"when unknown name is given": {
+			names:            []string{"foo", "qux"},
+			expectErr:        true,
+			expectErrMessage: "collector not found: qux",
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			seeded := map[string]string{}
+			for _, c := range cfg.Collectors {
+				writePastPosition(t, c.PositionFile)
+				pos, err := oslog_collector.ReadPosition(c.PositionFile)
+				require.NoError(t, err)
+				seeded[c.Name] = pos.LastTimestamp
+			}
+
+			results, err := oslog_collector.RunOnce(context.Background(), cfg, tt.names, oslog_collector.WithLogCommandRunner(dummyRunnerGeenerator))
+			if tt.expectErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMessage)
+				return
+			}
+
+			require.NoError(t, err)
+
+			succeeded := map[string]bool{}
+			for _, result := range results {
+				succeeded[result.Name] = result.Err == nil
+			}
+			assert.Equal(t, tt.expectResults, succeeded)
+
+			for name, ok := range tt.expectResults {
+				pos, err := oslog_collector.ReadPosition(filepath.Join(workdir, name+".pos"))
+				require.NoError(t, err)
+				assert.Equal(t, ok, pos.LastTimestamp != seeded[name], "position file of %s is updated only when the collection succeeded", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// outputFile is the output file of a collector or an archive watcher. The zero value is closed, and the file is not
+// opened if the path is empty because the logs are only delivered to the outputs or the sink.
+type outputFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// open opens the file at the path, or reopens it after it is rotated. The file opened before is kept if the new one
+// cannot be opened.
+func (f *outputFile) open(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.path = path
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening file: %v", err)
+	}
+
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	return nil
+}
+
+func (f *outputFile) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// sync commits the file to the disk.
+func (f *outputFile) sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing file: %v", err)
+	}
+	return nil
+}
+
+// write appends the data to the file, or does nothing if the path is empty.
+func (f *outputFile) write(data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.path == "" {
+		return nil
+	}
+
+	if f.file == nil {
+		return fmt.Errorf("file is not open")
+	}
+
+	if _, err := f.file.Write(data); err != nil {
+		return fmt.Errorf("error writing to file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package oslog_collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output receives the log entries collected by a collector, in addition to the output file.
+type Output interface {
+	// Write delivers the batch. The position is committed only if every output returns nil.
+	Write(ctx context.Context, batch *LogBatch) error
+	Close() error
+}
+
+// Processor transforms the log entries of a batch before they are delivered to the outputs and the sink.
+// It does not change the output file.
+type Processor interface {
+	// Process returns the entries to deliver, which may be filtered, modified or added.
+	Process(ctx context.Context, entries []LogEntry) ([]LogEntry, error)
+}
+
+// OutputFactory creates an output from its section in outputs of a collector. The node is the mapping of the section
+// including the type key. It is called when the config is parsed to validate the section, and again when the
+// collector is created, so it should not connect to anything before the first Write.
+type OutputFactory func(node *yaml.Node) (Output, error)
+
+// ProcessorFactory creates a processor from its section in processors of a collector. The node is the mapping of the section
+// including the type key. It is called when the config is parsed to validate the section, and again when the collector is created.
+type ProcessorFactory func(node *yaml.Node) (Processor, error)
+
+var (
+	registryMu sync.RWMutex
+	outputs    = map[string]OutputFactory{}
+	processors = map[string]ProcessorFactory{}
+)
+
+// RegisterOutput makes an output available as the type in outputs of collectors. It is intended to be called in the
+// init function of the package of the output, and panics if the name is empty or already registered.
+func RegisterOutput(name string, factory OutputFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" || factory == nil {
+		panic("oslog_collector: RegisterOutput requires a name and a factory")
+	}
+	if _, ok := outputs[name]; ok {
+		panic("oslog_collector: RegisterOutput called twice for output " + name)
+	}
+	outputs[name] = factory
+}
+
+// RegisterProcessor makes a processor available as the type in processors of collectors. It is intended to be called in the
+// init function of the package of the processor, and panics if the name is empty or already registered.
+func RegisterProcessor(name string, factory ProcessorFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" || factory == nil {
+		panic("oslog_collector: RegisterProcessor requires a name and a factory")
+	}
+	if _, ok := processors[name]; ok {
+		panic("oslog_collector: RegisterProcessor called twice for processor " + name)
+	}
+	processors[name] = factory
+}
+
+// RegisteredOutputs returns the sorted names of the registered outputs.
+func RegisteredOutputs() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return sortedKeys(outputs)
+}
+
+// RegisteredProcessors returns the sorted names of the registered processors.
+func RegisteredProcessors() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return sortedKeys(processors)
+}
+
+// PluginConfig is a section in outputs or processors of a collector. The type selects the registered output or processor,
+// and the other keys are the settings passed to its factory. The section is kept without the positions and the comments
+// in the config file, so that a reload does not restart the collector when only the lines above it move.
+type PluginConfig struct {
+	Type string
+
+	node *yaml.Node
+}
+
+func (p *PluginConfig) UnmarshalYAML(node *yaml.Node) error {
+	var section struct {
+		Type string `yaml:"type"`
+	}
+	if err := node.Decode(&section); err != nil {
+		return err
+	}
+
+	p.Type = section.Type
+	p.node = withoutPositions(node)
+	return nil
+}
+
+// withoutPositions returns a copy of the node without the lines, the columns and the comments.
+func withoutPositions(node *yaml.Node) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+
+	copied := *node
+	copied.Line, copied.Column = 0, 0
+	copied.HeadComment, copied.LineComment, copied.FootComment = "", "", ""
+	copied.Alias = withoutPositions(node.Alias)
+	copied.Content = nil
+	for _, child := range node.Content {
+		copied.Content = append(copied.Content, withoutPositions(child))
+	}
+	return &copied
+}
+
+func (p PluginConfig) MarshalYAML() (any, error) {
+	if p.node == nil {
+		return map[string]string{"type": p.Type}, nil
+	}
+	return p.node, nil
+}
+
+// Node returns the mapping of the section passed to the factory.
+func (p PluginConfig) Node() *yaml.Node {
+	if p.node == nil {
+		var node yaml.Node
+		// encoding a map of a string never fails
+		_ = node.Encode(map[string]string{"type": p.Type})
+		return &node
+	}
+	return p.node
+}
+
+// newOutput creates the output of the section with the registered factory.
+func newOutput(config PluginConfig) (Output, error) {
+	registryMu.RLock()
+	factory, ok := outputs[config.Type]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown output type %q, registered: %v", config.Type, RegisteredOutputs())
+	}
+
+	output, err := factory(config.Node())
+	if err != nil {
+		return nil, fmt.Errorf("output %s: %v", config.Type, err)
+	}
+	return output, nil
+}
+
+// newProcessor creates the processor of the section with the registered factory.
+func newProcessor(config PluginConfig) (Processor, error) {
+	registryMu.RLock()
+	factory, ok := processors[config.Type]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown processor type %q, registered: %v", config.Type, RegisteredProcessors())
+	}
+
+	processor, err := factory(config.Node())
+	if err != nil {
+		return nil, fmt.Errorf("processor %s: %v", config.Type, err)
+	}
+	return processor, nil
+}
+
+// typedOutput is an output with its type for the errors.
+type typedOutput struct {
+	Output
+	typ string
+}
+
+// newPlugins creates the outputs and the processors of a collector or an archive watcher.
+// The outputs created before an error are closed.
+func newPlugins(outputConfigs, processorConfigs []PluginConfig) ([]typedOutput, []Processor, error) {
+	var outs []typedOutput
+	for _, c := range outputConfigs {
+		output, err := newOutput(c)
+		if err != nil {
+			return nil, nil, errors.Join(err, closeOutputs(outs))
+		}
+		outs = append(outs, typedOutput{Output: output, typ: c.Type})
+	}
+
+	var procs []Processor
+	for _, c := range processorConfigs {
+		processor, err := newProcessor(c)
+		if err != nil {
+			return nil, nil, errors.Join(err, closeOutputs(outs))
+		}
+		procs = append(procs, processor)
+	}
+
+	return outs, procs, nil
+}
+
+func closeOutputs(outs []typedOutput) error {
+	var errs []error
+	for _, output := range outs {
+		errs = append(errs, output.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// validatePlugins validates the outputs and the processors by creating them with their factories.
+func validatePlugins(outputConfigs, processorConfigs []PluginConfig) error {
+	outs, _, err := newPlugins(outputConfigs, processorConfigs)
+	if err != nil {
+		return err
+	}
+	return closeOutputs(outs)
+}
+
+// process applies the processors to the batch in order.
+func (p *pipeline) process(ctx context.Context, batch *LogBatch) error {
+	for _, processor := range p.processors {
+		entries, err := processor.Process(ctx, batch.Entries)
+		if err != nil {
+			return fmt.Errorf("error processing logs: %w", err)
+		}
+		batch.Entries = entries
+	}
+	return nil
+}
+
+// writeOutputs delivers the batch to every output that has not received it, and returns the errors of the outputs
+// that failed.
+func (p *pipeline) writeOutputs(ctx context.Context, batch *LogBatch) error {
+	var errs []error
+	for i, output := range p.outputs {
+		if _, ok := p.delivered[i]; ok {
+			continue
+		}
+		if err := output.Write(ctx, batch); err != nil {
+			errs = append(errs, fmt.Errorf("error writing logs to output %s: %w", output.typ, err))
+			continue
+		}
+		p.delivered[i] = struct{}{}
+	}
+	return errors.Join(errs...)
+}
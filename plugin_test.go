@@ -0,0 +1,357 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// recordingOutput records the batches written to it, and fails if fail is set.
+type recordingOutput struct {
+	Prefix string `yaml:"prefix"`
+
+	fail     bool
+	messages []string
+	closed   bool
+}
+
+func (o *recordingOutput) Write(ctx context.Context, batch *oslog_collector.LogBatch) error {
+	if o.fail {
+		return errors.New("output error")
+	}
+	for _, entry := range batch.Entries {
+		o.messages = append(o.messages, o.Prefix+entry.EventMessage)
+	}
+	return nil
+}
+
+func (o *recordingOutput) Close() error {
+	o.closed = true
+	return nil
+}
+
+// dropProcessor drops the entries of the message.
+type dropProcessor struct {
+	Message string `yaml:"message"`
+}
+
+func (p *dropProcessor) Process(ctx context.Context, entries []oslog_collector.LogEntry) ([]oslog_collector.LogEntry, error) {
+	return slices.DeleteFunc(entries, func(e oslog_collector.LogEntry) bool { return e.EventMessage == p.Message }), nil
+}
+
+// createdOutputs records the outputs created by the factory of the test, which are read by the tests not running in parallel.
+var createdOutputs []*recordingOutput
+
+func init() {
+	oslog_collector.RegisterOutput("test_recording", func(node *yaml.Node) (oslog_collector.Output, error) {
+		output := &recordingOutput{}
+		if err := node.Decode(output); err != nil {
+			return nil, err
+		}
+		if strings.Contains(output.Prefix, " ") {
+			return nil, fmt.Errorf("prefix must not contain spaces")
+		}
+		createdOutputs = append(createdOutputs, output)
+		return output, nil
+	})
+	oslog_collector.RegisterProcessor("test_drop", func(node *yaml.Node) (oslog_collector.Processor, error) {
+		processor := &dropProcessor{}
+		if err := node.Decode(processor); err != nil {
+			return nil, err
+		}
+		return processor, nil
+	})
+}
+
+func TestParseConfig_Plugins(t *testing.T) {
+	testCases := map[string]struct {
+		plugins          string
+		expectErrMessage string
+	}{
+		"when outputs and processors are registered": {
+			plugins: `
+    outputs:
+      - type: test_recording
+        prefix: "foo:"
+    processors:
+      - type: test_drop
+        message: bar`,
+		},
+		"when output is not registered": {
+			plugins: `
+    outputs:
+      - type: unknown`,
+			expectErrMessage: `unknown output type "unknown", registered: [`,
+		},
+		"when processor is not registered": {
+			plugins: `
+    output_file: /var/log/foo.log
+    processors:
+      - type: unknown`,
+			expectErrMessage: `unknown processor type "unknown", registered: [`,
+		},
+		"when factory rejects the section": {
+			plugins: `
+    outputs:
+      - type: test_recording
+        prefix: "foo bar"`,
+			expectErrMessage: "collector foo: output test_recording: prefix must not contain spaces",
+		},
+		"when neither output_file nor outputs are set": {
+			expectErrMessage: "output_file is required",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			config := `
+collectors:
+  - name: foo
+    predicate: "process == 'foo'"
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60` + tc.plugins
+
+			cfg, err := oslog_collector.ParseConfig([]byte(config))
+			if tc.expectErrMessage != "" {
+				assert.ErrorContains(t, err, tc.expectErrMessage)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, "test_recording", cfg.Collectors[0].Outputs[0].Type)
+			assert.Equal(t, "test_drop", cfg.Collectors[0].Processors[0].Type)
+
+			dumped, err := cfg.Dump()
+			require.NoError(t, err)
+			assert.Contains(t, string(dumped), "prefix: \"foo:\"")
+		})
+	}
+}
+
+func TestOSLogCollector_Plugins(t *testing.T) {
+	flextime.Fix(time.Date(2025, 1, 29, 0, 1, 0, 0, time.UTC))
+	defer flextime.Restore()
+
+	cfg, err := oslog_collector.ParseConfig([]byte(`
+collectors:
+  - name: test
+    predicate: "process == 'test'"
+    position_file: ` + filepath.Join(t.TempDir(), "test.pos") + `
+    interval: 60
+    outputs:
+      - type: test_recording
+        prefix: "test:"
+    processors:
+      - type: test_drop
+        message: bar
+`))
+	require.NoError(t, err)
+	require.NoError(t, oslog_collector.WritePosition(cfg.Collectors[0].PositionFile, oslog_collector.Position{LastTimestamp: "2025-01-29T00:00:00Z"}))
+
+	createdOutputs = nil
+	sinkFails, sinkCalls := false, 0
+	var ends []string
+	collector, err := oslog_collector.NewOSLogCollector(cfg.Collectors[0], oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		ends = append(ends, args[slices.Index(args, "--end")+1])
+		return outputRunner(entriesOutput)
+	}), oslog_collector.WithSink(func(ctx context.Context, batch *oslog_collector.LogBatch) error {
+		sinkCalls++
+		if sinkFails {
+			return errors.New("sink error")
+		}
+		return nil
+	}))
+	require.NoError(t, err)
+	require.Len(t, createdOutputs, 1)
+	output := createdOutputs[0]
+
+	// the sink receives the window while the output fails
+	output.fail = true
+	sinkFails = false
+	assert.ErrorContains(t, collector.CollectLogs(context.Background()), "error writing logs to output test_recording: output error")
+	assert.Equal(t, "2025-01-29T00:00:00Z", collector.LastTimestamp)
+	assert.Equal(t, 1, sinkCalls)
+
+	// the output receives the failed window with the same end after the clock advances, and the sink that received
+	// it is not called again before the window up to now
+	flextime.Fix(time.Date(2025, 1, 29, 0, 2, 0, 0, time.UTC))
+	output.fail = false
+	sinkFails = true
+	assert.ErrorContains(t, collector.CollectLogs(context.Background()), "sink error")
+	assert.Equal(t, []string{"test:foo", "test:foo"}, output.messages)
+	assert.Equal(t, 2, sinkCalls)
+	assert.Equal(t, "2025-01-29T00:01:00Z", collector.LastTimestamp)
+
+	// the window up to the previous now is collected again with the same end, and the output that received it is skipped
+	flextime.Fix(time.Date(2025, 1, 29, 0, 3, 0, 0, time.UTC))
+	sinkFails = false
+	require.NoError(t, collector.CollectLogs(context.Background()))
+	assert.Equal(t, []string{"test:foo", "test:foo", "test:foo"}, output.messages)
+	assert.Equal(t, 4, sinkCalls)
+	assert.Equal(t, "2025-01-29T00:03:00Z", collector.LastTimestamp)
+
+	assert.Equal(t, []string{
+		"2025-01-29 00:01:00+0000",
+		"2025-01-29 00:01:00+0000",
+		"2025-01-29 00:02:00+0000",
+		"2025-01-29 00:02:00+0000",
+		"2025-01-29 00:03:00+0000",
+	}, ends)
+
+	require.NoError(t, collector.Close())
+	assert.True(t, output.closed)
+}
+
+func TestOSLogCollector_OutputFileFailure(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+
+	flextime.Fix(time.Date(2025, 1, 29, 0, 1, 0, 0, time.UTC))
+	defer flextime.Restore()
+
+	positionFile := filepath.Join(t.TempDir(), "test.pos")
+	require.NoError(t, oslog_collector.WritePosition(positionFile, oslog_collector.Position{LastTimestamp: "2025-01-29T00:00:00Z"}))
+
+	sinkCalls := 0
+	var ends []string
+	collector, err := oslog_collector.NewOSLogCollector(oslog_collector.OSLogCollectorConfig{
+		Name:         "test",
+		Predicate:    "process == 'test'",
+		OutputFile:   "/dev/full",
+		PositionFile: positionFile,
+		Interval:     60,
+	}, oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		ends = append(ends, args[slices.Index(args, "--end")+1])
+		return outputRunner(entriesOutput)
+	}), oslog_collector.WithSink(func(ctx context.Context, batch *oslog_collector.LogBatch) error {
+		sinkCalls++
+		return nil
+	}))
+	require.NoError(t, err)
+	defer collector.Close()
+
+	// the window is collected again with the same end after the clock advances, and the sink that acknowledged it
+	// does not receive it again while the output file fails
+	for range 2 {
+		assert.ErrorContains(t, collector.CollectLogs(context.Background()), "error writing to file")
+		flextime.Fix(flextime.Now().Add(time.Minute))
+	}
+	assert.Equal(t, 1, sinkCalls)
+	assert.Equal(t, "2025-01-29T00:00:00Z", collector.LastTimestamp)
+	assert.Equal(t, []string{"2025-01-29 00:01:00+0000", "2025-01-29 00:01:00+0000"}, ends)
+}
+
+func TestRegisterOutput_Duplicate(t *testing.T) {
+	assert.Contains(t, oslog_collector.RegisteredOutputs(), "test_recording")
+	assert.Contains(t, oslog_collector.RegisteredProcessors(), "test_drop")
+
+	assert.Panics(t, func() {
+		oslog_collector.RegisterOutput("test_recording", func(node *yaml.Node) (oslog_collector.Output, error) { return nil, nil })
+	})
+	assert.Panics(t, func() {
+		oslog_collector.RegisterProcessor("", func(node *yaml.Node) (oslog_collector.Processor, error) { return nil, nil })
+	})
+}
+
+func TestPluginConfig_Equal(t *testing.T) {
+	section := `
+    outputs:
+      - type: test_recording
+        prefix: "foo:" # the prefix of the messages`
+
+	parse := func(config string) oslog_collector.OSLogCollectorConfig {
+		t.Helper()
+		cfg, err := oslog_collector.ParseConfig([]byte(config))
+		require.NoError(t, err)
+		return cfg.Collectors[0]
+	}
+
+	collector := `
+collectors:
+  - name: foo
+    predicate: "process == 'foo'"
+    position_file: /var/lib/oslog-collector/foo.pos
+    interval: 60`
+
+	original := parse(collector + section)
+	// the section is moved down by the added lines and its comment is changed
+	moved := parse("# collectors\n\n" + collector + strings.Replace(section, "the prefix of the messages", "prefix", 1))
+	changed := parse(collector + strings.Replace(section, "foo:", "bar:", 1))
+
+	assert.True(t, reflect.DeepEqual(original, moved), "moved section is not changed")
+	assert.False(t, reflect.DeepEqual(original, changed), "changed setting is changed")
+}
+
+func TestArchiveWatcher_Plugins(t *testing.T) {
+	flextime.Set(time.Date(2025, 1, 29, 0, 0, 0, 0, time.Now().Location()))
+	defer flextime.Restore()
+
+	workdir := t.TempDir()
+	dropDir := filepath.Join(workdir, "drop")
+	require.NoError(t, os.MkdirAll(filepath.Join(dropDir, "test.logarchive"), 0755))
+
+	cfg, err := oslog_collector.ParseConfig([]byte(`
+archive_watchers:
+  - name: test
+    directory: ` + dropDir + `
+    predicate: "process == 'test'"
+    state_file: ` + filepath.Join(workdir, "archive.state") + `
+    interval: 1
+    stable_seconds: 10
+    outputs:
+      - type: test_recording
+        prefix: "test:"
+    processors:
+      - type: test_drop
+        message: bar
+`))
+	require.NoError(t, err)
+
+	createdOutputs = nil
+	runs := 0
+	watcher, err := oslog_collector.NewArchiveWatcher(cfg.ArchiveWatchers[0], oslog_collector.WithArchiveWatcherLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		runs++
+		return outputRunner(entriesOutput)
+	}))
+	require.NoError(t, err)
+	require.Len(t, createdOutputs, 1)
+	output := createdOutputs[0]
+
+	process := func() {
+		t.Helper()
+		require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+		flextime.Set(flextime.Now().Add(10 * time.Second))
+		require.NoError(t, watcher.ProcessNewArchives(context.Background()))
+	}
+
+	// the bundle is processed again after the output fails
+	output.fail = true
+	process()
+	assert.Equal(t, 1, runs)
+	assert.Empty(t, output.messages)
+
+	output.fail = false
+	process()
+	assert.Equal(t, 2, runs)
+	assert.Equal(t, []string{"test:foo"}, output.messages)
+
+	process()
+	assert.Equal(t, 2, runs)
+
+	require.NoError(t, watcher.Close())
+	assert.True(t, output.closed)
+}
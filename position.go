@@ -0,0 +1,201 @@
+package oslog_collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// PositionTimeFormat is the format of the positions, which is RFC 3339 with the offset from UTC.
+var PositionTimeFormat = time.RFC3339
+
+type Position struct {
+	// LastTimestamp is the end of the last collected window in PositionTimeFormat.
+	// Positions saved without the offset by older versions are migrated when the collector starts.
+	LastTimestamp string `json:"last_timestamp"`
+}
+
+// PositionChange is the position of a collector before and after it is modified.
+// Before and After are empty if the position file does not exist.
+type PositionChange struct {
+	Name         string
+	PositionFile string
+	Before       string
+	After        string
+}
+
+// ReadPosition reads the position file. It returns nil if the file does not exist.
+func ReadPosition(path string) (*Position, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading position file: %v", err)
+	}
+
+	var pos Position
+	if err := json.Unmarshal(data, &pos); err != nil {
+		return nil, fmt.Errorf("error parsing position file: %v", err)
+	}
+
+	return &pos, nil
+}
+
+// WritePosition replaces the position file atomically, so that a crash does not leave a truncated position.
+func WritePosition(path string, pos Position) error {
+	data, err := json.Marshal(pos)
+	if err != nil {
+		return fmt.Errorf("error marshaling position: %v", err)
+	}
+
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing position file: %v", err)
+	}
+
+	return nil
+}
+
+// ShowPositions returns the positions of the collectors with the names, or all collectors if no names are given.
+// Before and After of the returned changes are the same.
+func ShowPositions(config *Config, names []string) ([]PositionChange, error) {
+	return modifyPositions(config, names, false, func(_ PositionChange, before *Position) (*Position, error) {
+		return before, nil
+	})
+}
+
+// SetPositions sets the positions of the collectors to t.
+func SetPositions(config *Config, names []string, t time.Time) ([]PositionChange, error) {
+	return modifyPositions(config, names, true, func(_ PositionChange, _ *Position) (*Position, error) {
+		return &Position{LastTimestamp: t.Format(PositionTimeFormat)}, nil
+	})
+}
+
+// RewindPositions moves the positions of the collectors back by d.
+// The position of a collector without the position file is rewound from now, which is where it would start.
+func RewindPositions(config *Config, names []string, d time.Duration) ([]PositionChange, error) {
+	return modifyPositions(config, names, true, func(change PositionChange, before *Position) (*Position, error) {
+		t := flextime.Now()
+		if before != nil {
+			var err error
+			if t, err = parseLogTimestamp(before.LastTimestamp); err != nil {
+				return nil, fmt.Errorf("collector %s: invalid position: %v", change.Name, err)
+			}
+		}
+
+		return &Position{LastTimestamp: t.Add(-d).Format(PositionTimeFormat)}, nil
+	})
+}
+
+// ResetPositions removes the position files of the collectors, so that they start collecting from now.
+func ResetPositions(config *Config, names []string) ([]PositionChange, error) {
+	return modifyPositions(config, names, true, func(_ PositionChange, _ *Position) (*Position, error) {
+		return nil, nil
+	})
+}
+
+// modifyPositions applies modify to the position of each selected collector.
+// If modify returns nil, the position file is removed. If write is true, it refuses to modify positions
+// while the agent is running because the agent would overwrite them.
+// Every position file is locked and read, and every new position is computed, before any of them is written, so that
+// nothing is changed if a collector is running or a position is invalid. If writing a position file fails,
+// the returned changes are the ones already written.
+func modifyPositions(config *Config, names []string, write bool, modify func(PositionChange, *Position) (*Position, error)) ([]PositionChange, error) {
+	collectors, err := selectCollectors(config, names)
+	if err != nil {
+		return nil, err
+	}
+
+	if write {
+		if err := checkAgentNotRunning(config.PIDFile); err != nil {
+			return nil, fmt.Errorf("%v, stop it before modifying positions", err)
+		}
+	}
+
+	if write {
+		var locks []*fileLock
+		defer func() {
+			for _, lock := range locks {
+				lock.Unlock()
+			}
+		}()
+
+		for _, c := range collectors {
+			// a collector running without the PID file still holds the lock of its position file
+			lock, err := lockStateFile(c.PositionFile)
+			if err != nil {
+				return nil, fmt.Errorf("collector %s: %v", c.Name, err)
+			}
+			locks = append(locks, lock)
+		}
+	}
+
+	changes := make([]PositionChange, 0, len(collectors))
+	positions := make([]*Position, 0, len(collectors))
+	for _, c := range collectors {
+		change := PositionChange{Name: c.Name, PositionFile: c.PositionFile}
+
+		before, err := ReadPosition(c.PositionFile)
+		if err != nil {
+			return nil, fmt.Errorf("collector %s: %v", c.Name, err)
+		}
+		if before != nil {
+			change.Before = before.LastTimestamp
+		}
+
+		after, err := modify(change, before)
+		if err != nil {
+			return nil, err
+		}
+		if after != nil {
+			change.After = after.LastTimestamp
+		}
+
+		changes = append(changes, change)
+		positions = append(positions, after)
+	}
+
+	if !write {
+		return changes, nil
+	}
+
+	for i, c := range collectors {
+		if err := writeOrRemovePosition(c.PositionFile, positions[i]); err != nil {
+			return changes[:i], fmt.Errorf("collector %s: %v", c.Name, err)
+		}
+	}
+
+	return changes, nil
+}
+
+func writeOrRemovePosition(path string, pos *Position) error {
+	if pos != nil {
+		return WritePosition(path, *pos)
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing position file: %v", err)
+	}
+	return nil
+}
+
+// selectCollectors returns the collectors with the names, or all collectors if no names are given.
+func selectCollectors(config *Config, names []string) ([]OSLogCollectorConfig, error) {
+	for _, name := range names {
+		if !slices.ContainsFunc(config.Collectors, func(c OSLogCollectorConfig) bool { return c.Name == name }) {
+			return nil, fmt.Errorf("collector not found: %s", name)
+		}
+	}
+
+	collectors := make([]OSLogCollectorConfig, 0, len(config.Collectors))
+	for _, c := range config.Collectors {
+		if len(names) == 0 || slices.Contains(names, c.Name) {
+			collectors = append(collectors, c)
+		}
+	}
+
+	return collectors, nil
+}
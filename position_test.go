@@ -0,0 +1,202 @@
+package oslog_collector_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositions(t *testing.T) {
+	flextime.Set(time.Date(2025, 1, 29, 12, 0, 0, 0, time.UTC))
+	defer flextime.Restore()
+
+	setup := func(t *testing.T) *oslog_collector.Config {
+		workdir := t.TempDir()
+		require.NoError(t, oslog_collector.WritePosition(filepath.Join(workdir, "foo.pos"), oslog_collector.Position{LastTimestamp: "2025-01-29T11:00:00Z"}))
+
+		return &oslog_collector.Config{
+			PIDFile: filepath.Join(workdir, "oslog-collector.pid"),
+			Collectors: []oslog_collector.OSLogCollectorConfig{
+				{Name: "foo", PositionFile: filepath.Join(workdir, "foo.pos")},
+				{Name: "bar", PositionFile: filepath.Join(workdir, "bar.pos")},
+			},
+		}
+	}
+
+	testCases := map[string]struct {
+		run           func(cfg *oslog_collector.Config) ([]oslog_collector.PositionChange, error)
+		expectChanges map[string][2]string
+	}{
+		"show": {
+			run: func(cfg *oslog_collector.Config) ([]oslog_collector.PositionChange, error) {
+				return oslog_collector.ShowPositions(cfg, nil)
+			},
+			expectChanges: map[string][2]string{
+				"foo": {"2025-01-29T11:00:00Z", "2025-01-29T11:00:00Z"},
+				"bar": {"", ""},
+			},
+		},
+		"set": {
+			run: func(cfg *oslog_collector.Config) ([]oslog_collector.PositionChange, error) {
+				return oslog_collector.SetPositions(cfg, []string{"foo"}, time.Date(2025, 1, 29, 6, 0, 0, 0, time.UTC))
+			},
+			expectChanges: map[string][2]string{
+				"foo": {"2025-01-29T11:00:00Z", "2025-01-29T06:00:00Z"},
+			},
+		},
+		"rewind": {
+			run: func(cfg *oslog_collector.Config) ([]oslog_collector.PositionChange, error) {
+				return oslog_collector.RewindPositions(cfg, nil, 2*time.Hour)
+			},
+			expectChanges: map[string][2]string{
+				"foo": {"2025-01-29T11:00:00Z", "2025-01-29T09:00:00Z"},
+				// the collector without the position file would start from now
+				"bar": {"", "2025-01-29T10:00:00Z"},
+			},
+		},
+		"reset": {
+			run: func(cfg *oslog_collector.Config) ([]oslog_collector.PositionChange, error) {
+				return oslog_collector.ResetPositions(cfg, []string{"foo"})
+			},
+			expectChanges: map[string][2]string{
+				"foo": {"2025-01-29T11:00:00Z", ""},
+			},
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			cfg := setup(t)
+
+			changes, err := tt.run(cfg)
+			require.NoError(t, err)
+
+			actual := map[string][2]string{}
+			for _, change := range changes {
+				actual[change.Name] = [2]string{change.Before, change.After}
+			}
+			assert.Equal(t, tt.expectChanges, actual)
+
+			// the position files have the values after the change
+			positions, err := oslog_collector.ShowPositions(cfg, nil)
+			require.NoError(t, err)
+			for _, pos := range positions {
+				if change, ok := tt.expectChanges[pos.Name]; ok {
+					assert.Equal(t, change[1], pos.Before)
+				}
+			}
+		})
+	}
+
+	t.Run("when collector is not found", func(t *testing.T) {
+		_, err := oslog_collector.ResetPositions(setup(t), []string{"baz"})
+		assert.EqualError(t, err, "collector not found: baz")
+	})
+
+	t.Run("when agent is running then refuse to modify positions", func(t *testing.T) {
+		cfg := setup(t)
+		agent := &oslog_collector.Agent{Config: cfg, ShutdownCh: make(chan struct{})}
+
+		done := make(chan error)
+		go func() {
+			done <- agent.Run()
+		}()
+		require.Eventually(t, func() bool {
+			_, err := os.Stat(cfg.PIDFile)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		_, err := oslog_collector.SetPositions(cfg, nil, flextime.Now())
+		assert.EqualError(t, err, fmt.Sprintf("oslog-collector agent is already running (pid %d), stop it before modifying positions", os.Getpid()))
+
+		_, err = oslog_collector.ShowPositions(cfg, nil)
+		assert.NoError(t, err, "positions can be shown while the agent is running")
+
+		agent.ShutdownCh <- struct{}{}
+		require.NoError(t, <-done)
+	})
+
+	t.Run("when collector is running then refuse to modify its position", func(t *testing.T) {
+		cfg := setup(t)
+		cfg.Collectors[0].OutputFile = filepath.Join(t.TempDir(), "foo.log")
+
+		collector, err := oslog_collector.NewOSLogCollector(cfg.Collectors[0])
+		require.NoError(t, err)
+		defer collector.Close()
+
+		_, err = oslog_collector.ResetPositions(cfg, []string{"foo"})
+		assert.ErrorContains(t, err, "foo.pos is locked by another oslog-collector process")
+
+		_, err = oslog_collector.ResetPositions(cfg, []string{"bar"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("when one of the collectors is running then modify no position", func(t *testing.T) {
+		cfg := setup(t)
+		cfg.Collectors[1].OutputFile = filepath.Join(t.TempDir(), "bar.log")
+		before, err := oslog_collector.ShowPositions(cfg, nil)
+		require.NoError(t, err)
+
+		collector, err := oslog_collector.NewOSLogCollector(cfg.Collectors[1])
+		require.NoError(t, err)
+		defer collector.Close()
+
+		changes, err := oslog_collector.ResetPositions(cfg, nil)
+		assert.ErrorContains(t, err, "collector bar: ")
+		assert.Empty(t, changes)
+
+		after, err := oslog_collector.ShowPositions(cfg, nil)
+		require.NoError(t, err)
+		assert.Equal(t, before, after, "the position of foo is not reset")
+	})
+
+	t.Run("when PID file is stale then modify positions", func(t *testing.T) {
+		cfg := setup(t)
+		require.NoError(t, os.WriteFile(cfg.PIDFile, []byte("2147483647"), 0600))
+
+		_, err := oslog_collector.ResetPositions(cfg, nil)
+		assert.NoError(t, err)
+	})
+}
+
+func TestWritePosition(t *testing.T) {
+	workdir := t.TempDir()
+	cfg := oslog_collector.OSLogCollectorConfig{
+		Name:         "foo",
+		Predicate:    "process == 'foo'",
+		OutputFile:   filepath.Join(workdir, "foo.log"),
+		PositionFile: filepath.Join(workdir, "foo.pos"),
+		Interval:     60,
+	}
+
+	collector, err := oslog_collector.NewOSLogCollector(cfg)
+	require.NoError(t, err)
+	defer collector.Close()
+
+	require.NoError(t, oslog_collector.WritePosition(cfg.PositionFile, oslog_collector.Position{LastTimestamp: "2025-01-29T11:00:00Z"}))
+	require.NoError(t, oslog_collector.WritePosition(cfg.PositionFile, oslog_collector.Position{LastTimestamp: "2025-01-29T12:00:00Z"}))
+
+	pos, err := oslog_collector.ReadPosition(cfg.PositionFile)
+	require.NoError(t, err)
+	assert.Equal(t, "2025-01-29T12:00:00Z", pos.LastTimestamp)
+
+	entries, err := os.ReadDir(workdir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"foo.log", "foo.pos", "foo.pos.lock"}, names, "no temporary file is left")
+
+	_, err = oslog_collector.NewOSLogCollector(cfg)
+	assert.ErrorContains(t, err, "foo.pos is locked by another oslog-collector process", "the lock is kept after the file is replaced")
+}
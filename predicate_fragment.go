@@ -0,0 +1,97 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var predicateFragmentNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// predicateExpander expands the references to named predicate fragments, e.g. ${security_subsystems}.
+type predicateExpander struct {
+	fragments map[string]string
+	expanded  map[string]string
+}
+
+func newPredicateExpander(fragments map[string]string) *predicateExpander {
+	return &predicateExpander{
+		fragments: fragments,
+		expanded:  map[string]string{},
+	}
+}
+
+func (e *predicateExpander) expand(predicate string) (string, error) {
+	return e.expandWithStack(predicate, nil)
+}
+
+// expandWithStack expands the references in the predicate, and stack is the fragments being expanded to detect cycles.
+func (e *predicateExpander) expandWithStack(predicate string, stack []string) (string, error) {
+	var result strings.Builder
+	var quote rune
+	escaped := false
+
+	for i := 0; i < len(predicate); i++ {
+		c := rune(predicate[i])
+
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.HasPrefix(predicate[i:], "${"):
+			end := strings.IndexByte(predicate[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated predicate reference at column %d", i+1)
+			}
+
+			fragment, err := e.expandFragment(predicate[i+2:i+end], stack)
+			if err != nil {
+				return "", err
+			}
+
+			result.WriteString(parenthesizePredicate(fragment))
+			i += end
+			continue
+		}
+
+		result.WriteByte(predicate[i])
+	}
+
+	return result.String(), nil
+}
+
+func (e *predicateExpander) expandFragment(name string, stack []string) (string, error) {
+	if !predicateFragmentNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid predicate reference: ${%s}", name)
+	}
+
+	for i, n := range stack {
+		if n == name {
+			return "", fmt.Errorf("predicate reference cycle: %s", strings.Join(append(stack[i:], name), " -> "))
+		}
+	}
+
+	if expanded, ok := e.expanded[name]; ok {
+		return expanded, nil
+	}
+
+	fragment, ok := e.fragments[name]
+	if !ok {
+		return "", fmt.Errorf("undefined predicate: ${%s}", name)
+	}
+
+	expanded, err := e.expandWithStack(fragment, append(stack, name))
+	if err != nil {
+		return "", err
+	}
+
+	e.expanded[name] = expanded
+	return expanded, nil
+}
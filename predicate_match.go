@@ -0,0 +1,191 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var messageLevels = []string{"default", "info", "debug", "error", "fault"}
+
+// PredicateMatch is a structured form of a predicate, which is compiled to a NSPredicate string.
+// The conditions in a match are combined with AND, and the values of a condition are combined with OR.
+//
+//	match:
+//	  subsystem: [com.apple.mdns, com.apple.network]
+//	  message_level: [error, fault]
+//	  not:
+//	    message_contains: "heartbeat"
+type PredicateMatch struct {
+	// Subsystem matches the subsystem of the log
+	Subsystem StringList `yaml:"subsystem,omitempty"`
+	// Category matches the category of the log
+	Category StringList `yaml:"category,omitempty"`
+	// Process matches the process name of the log
+	Process StringList `yaml:"process,omitempty"`
+	// MessageContains matches the logs whose message contains the string
+	MessageContains StringList `yaml:"message_contains,omitempty"`
+	// MessageLevel matches the message type of the log, which is one of default, info, debug, error and fault
+	MessageLevel StringList `yaml:"message_level,omitempty"`
+	// Any matches the logs that match at least one of the matches
+	Any []PredicateMatch `yaml:"any,omitempty"`
+	// All matches the logs that match all of the matches
+	All []PredicateMatch `yaml:"all,omitempty"`
+	// Not matches the logs that do not match the match
+	Not *PredicateMatch `yaml:"not,omitempty"`
+}
+
+// StringList is a list of strings that can also be written as a single string in YAML.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = StringList{value.Value}
+		return nil
+	}
+
+	var values []string
+	if err := value.Decode(&values); err != nil {
+		return err
+	}
+
+	*l = values
+	return nil
+}
+
+// Compile compiles the match to a NSPredicate string.
+func (m *PredicateMatch) Compile() (string, error) {
+	clauses, err := m.compileClauses()
+	if err != nil {
+		return "", err
+	}
+
+	return joinPredicates("AND", clauses), nil
+}
+
+func (m *PredicateMatch) compileClauses() ([]string, error) {
+	var clauses []string
+
+	fields := []struct {
+		key      string
+		operator string
+		values   StringList
+	}{
+		{key: "subsystem", operator: "==", values: m.Subsystem},
+		{key: "category", operator: "==", values: m.Category},
+		{key: "process", operator: "==", values: m.Process},
+		{key: "eventMessage", operator: "CONTAINS", values: m.MessageContains},
+	}
+
+	for _, field := range fields {
+		conditions := make([]string, 0, len(field.values))
+		for _, value := range field.values {
+			conditions = append(conditions, fmt.Sprintf("%s %s %s", field.key, field.operator, quotePredicateString(value)))
+		}
+		if len(conditions) > 0 {
+			clauses = append(clauses, joinPredicates("OR", conditions))
+		}
+	}
+
+	levels := make([]string, 0, len(m.MessageLevel))
+	for _, level := range m.MessageLevel {
+		if !slices.Contains(messageLevels, level) {
+			return nil, fmt.Errorf("invalid message_level: %q, must be one of %s", level, strings.Join(messageLevels, ", "))
+		}
+		levels = append(levels, "messageType == "+level)
+	}
+	if len(levels) > 0 {
+		clauses = append(clauses, joinPredicates("OR", levels))
+	}
+
+	for _, combinator := range []struct {
+		name     string
+		operator string
+		matches  []PredicateMatch
+	}{
+		{name: "any", operator: "OR", matches: m.Any},
+		{name: "all", operator: "AND", matches: m.All},
+	} {
+		if combinator.matches == nil {
+			continue
+		}
+
+		predicates := make([]string, 0, len(combinator.matches))
+		for i := range combinator.matches {
+			predicate, err := combinator.matches[i].Compile()
+			if err != nil {
+				return nil, err
+			}
+			predicates = append(predicates, predicate)
+		}
+		if len(predicates) == 0 {
+			return nil, fmt.Errorf("%s must not be empty", combinator.name)
+		}
+		clauses = append(clauses, joinPredicates(combinator.operator, predicates))
+	}
+
+	if m.Not != nil {
+		predicate, err := m.Not.Compile()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, "NOT "+parenthesizePredicate(predicate))
+	}
+
+	if len(clauses) == 0 {
+		return nil, fmt.Errorf("match must have at least one condition")
+	}
+
+	return clauses, nil
+}
+
+// joinPredicates joins the predicates with the operator, and parenthesizes compound predicates.
+func joinPredicates(operator string, predicates []string) string {
+	if len(predicates) == 1 {
+		return predicates[0]
+	}
+
+	parts := make([]string, 0, len(predicates))
+	for _, predicate := range predicates {
+		parts = append(parts, parenthesizePredicate(predicate))
+	}
+	return strings.Join(parts, " "+operator+" ")
+}
+
+func parenthesizePredicate(predicate string) string {
+	if isCompoundPredicate(predicate) {
+		return "(" + predicate + ")"
+	}
+	return predicate
+}
+
+// isCompoundPredicate reports whether the predicate has AND, OR, && or || outside of parentheses, in any case.
+// A predicate that cannot be tokenized is regarded as compound.
+func isCompoundPredicate(predicate string) bool {
+	tokens, err := tokenizePredicate(predicate)
+	if err != nil {
+		return true
+	}
+
+	depth := 0
+	for _, token := range tokens {
+		switch {
+		case token.isSymbol("(", "{", "["):
+			depth++
+		case token.isSymbol(")", "}", "]"):
+			depth--
+		case depth == 0 && (token.isKeyword("AND", "OR") || token.isSymbol("&&", "||")):
+			return true
+		}
+	}
+
+	return false
+}
+
+// quotePredicateString returns a double-quoted NSPredicate string literal.
+func quotePredicateString(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(s) + `"`
+}
@@ -0,0 +1,107 @@
+package oslog_collector_test
+
+import (
+	"testing"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPredicateMatch_Compile(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		match            string
+		expectPredicate  string
+		expectErr        bool
+		expectErrMessage string
+	}{
+		"single subsystem": {
+			match:           `subsystem: com.apple.mdns`,
+			expectPredicate: `subsystem == "com.apple.mdns"`,
+		},
+		"multiple subsystems": {
+			match:           `subsystem: [com.apple.mdns, com.apple.network]`,
+			expectPredicate: `subsystem == "com.apple.mdns" OR subsystem == "com.apple.network"`,
+		},
+		"multiple conditions": {
+			match: `
+subsystem: [com.apple.mdns, com.apple.network]
+category: resolver
+process: mDNSResponder
+message_contains: query
+message_level: [error, fault]
+`,
+			expectPredicate: `(subsystem == "com.apple.mdns" OR subsystem == "com.apple.network") AND category == "resolver" AND process == "mDNSResponder" AND eventMessage CONTAINS "query" AND (messageType == error OR messageType == fault)`,
+		},
+		"escape quotes and backslashes": {
+			match:           `message_contains: 'it''s a "quoted" \path'`,
+			expectPredicate: `eventMessage CONTAINS "it's a \"quoted\" \\path"`,
+		},
+		"any": {
+			match: `
+any:
+  - subsystem: com.apple.mdns
+  - process: sshd
+    message_level: error
+`,
+			expectPredicate: `subsystem == "com.apple.mdns" OR (process == "sshd" AND messageType == error)`,
+		},
+		"all and not": {
+			match: `
+all:
+  - subsystem: [com.apple.mdns, com.apple.network]
+  - category: resolver
+not:
+  message_contains: [heartbeat, keepalive]
+`,
+			expectPredicate: `((subsystem == "com.apple.mdns" OR subsystem == "com.apple.network") AND category == "resolver") AND NOT (eventMessage CONTAINS "heartbeat" OR eventMessage CONTAINS "keepalive")`,
+		},
+		"operators in strings are not treated as compound predicates": {
+			match: `
+subsystem: com.apple.mdns
+not:
+  message_contains: " AND "
+`,
+			expectPredicate: `subsystem == "com.apple.mdns" AND NOT eventMessage CONTAINS " AND "`,
+		},
+		"invalid message level": {
+			match:            `message_level: warning`,
+			expectErr:        true,
+			expectErrMessage: `invalid message_level: "warning"`,
+		},
+		"empty match": {
+			match:            `{}`,
+			expectErr:        true,
+			expectErrMessage: "match must have at least one condition",
+		},
+		"empty any": {
+			match:            `any: []`,
+			expectErr:        true,
+			expectErrMessage: "any must not be empty",
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var match oslog_collector.PredicateMatch
+			require.NoError(t, yaml.Unmarshal([]byte(tt.match), &match))
+
+			predicate, err := match.Compile()
+			if tt.expectErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMessage)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectPredicate, predicate)
+		})
+	}
+}
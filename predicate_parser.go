@@ -0,0 +1,394 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+var (
+	// predicateKeys is a list of the keys that can be used in the predicate of the log command (see `log help predicates`)
+	predicateKeys = []string{
+		"activityIdentifier", "bootUUID", "category", "composedMessage", "continuousNanosecondsSinceBoot",
+		"creatorActivityIdentifier", "creatorProcessUniqueIdentifier", "date", "eventMessage", "eventType",
+		"formatString", "logType", "machContinuousTimestamp", "messageType", "parentActivityIdentifier",
+		"process", "processID", "processIdentifier", "processImagePath", "processImageUUID", "sender", "senderImageOffset",
+		"senderImagePath", "senderImageUUID", "signpostIdentifier", "signpostName", "signpostScope", "signpostType",
+		"size", "subsystem", "threadIdentifier", "timeZone", "timestamp", "traceIdentifier",
+		"transitionActivityIdentifier", "type",
+	}
+
+	// predicateConstants is a list of the bare words that can be compared with the keys, e.g. messageType == error
+	predicateConstants = []string{
+		// eventType
+		"activityCreateEvent", "activityTransitionEvent", "logEvent", "lossEvent", "signpostEvent", "stateEvent",
+		"timesyncEvent", "traceEvent", "userActionEvent",
+		// messageType and logType
+		"default", "release", "info", "debug", "error", "fault",
+		// signpostScope and signpostType
+		"thread", "system", "event", "begin", "end",
+	}
+
+	predicateLiterals = []string{"TRUE", "FALSE", "YES", "NO", "NIL", "NULL"}
+
+	predicateStringOperators = []string{"CONTAINS", "BEGINSWITH", "ENDSWITH", "LIKE", "MATCHES"}
+	predicateAggregates      = []string{"ANY", "ALL", "SOME", "NONE"}
+	predicateSymbolOperators = []string{"==", "=", "!=", "<>", "<=", "=<", ">=", "=>", "<", ">"}
+)
+
+// PredicateSyntaxError is an error in a predicate with the column (1-based) where it was found.
+type PredicateSyntaxError struct {
+	Column  int
+	Message string
+}
+
+func (e *PredicateSyntaxError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Message)
+}
+
+type predicateTokenKind int
+
+const (
+	predicateTokenEOF predicateTokenKind = iota
+	predicateTokenIdent
+	predicateTokenString
+	predicateTokenNumber
+	predicateTokenSymbol
+)
+
+type predicateToken struct {
+	kind   predicateTokenKind
+	value  string
+	column int
+}
+
+func (t predicateToken) String() string {
+	switch t.kind {
+	case predicateTokenEOF:
+		return "end of predicate"
+	case predicateTokenString:
+		return "string"
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+// isKeyword reports whether the token is the keyword, which is case-insensitive.
+func (t predicateToken) isKeyword(keywords ...string) bool {
+	if t.kind != predicateTokenIdent {
+		return false
+	}
+	for _, keyword := range keywords {
+		if strings.EqualFold(t.value, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t predicateToken) isSymbol(symbols ...string) bool {
+	return t.kind == predicateTokenSymbol && slices.Contains(symbols, t.value)
+}
+
+// ValidatePredicate parses the subset of the NSPredicate syntax accepted by the log command,
+// and returns a *PredicateSyntaxError if the predicate is invalid.
+func ValidatePredicate(predicate string) error {
+	tokens, err := tokenizePredicate(predicate)
+	if err != nil {
+		return err
+	}
+
+	p := &predicateParser{tokens: tokens}
+	if err := p.parseOr(); err != nil {
+		return err
+	}
+
+	if next := p.peek(); next.kind != predicateTokenEOF {
+		return p.errorf(next, "unexpected %s", next)
+	}
+
+	return nil
+}
+
+func tokenizePredicate(predicate string) ([]predicateToken, error) {
+	runes := []rune(predicate)
+	var tokens []predicateToken
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		column := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			var value strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' {
+					j++
+					if j >= len(runes) {
+						break
+					}
+				}
+				value.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, &PredicateSyntaxError{Column: column, Message: "unterminated string"}
+			}
+			tokens = append(tokens, predicateToken{kind: predicateTokenString, value: value.String(), column: column})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || unicode.IsLetter(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, predicateToken{kind: predicateTokenNumber, value: string(runes[i:j]), column: column})
+			i = j
+		case unicode.IsLetter(r) || r == '_' || r == '$':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, predicateToken{kind: predicateTokenIdent, value: string(runes[i:j]), column: column})
+			i = j
+		default:
+			symbol := ""
+			for _, s := range []string{"==", "!=", "<>", "<=", "=<", ">=", "=>", "&&", "||", "=", "<", ">", "!", "(", ")", "{", "}", "[", "]", ","} {
+				if strings.HasPrefix(string(runes[i:]), s) {
+					symbol = s
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, &PredicateSyntaxError{Column: column, Message: fmt.Sprintf("unexpected character %q", r)}
+			}
+			tokens = append(tokens, predicateToken{kind: predicateTokenSymbol, value: symbol, column: column})
+			i += len([]rune(symbol))
+		}
+	}
+
+	tokens = append(tokens, predicateToken{kind: predicateTokenEOF, column: len(runes) + 1})
+	return tokens, nil
+}
+
+type predicateParser struct {
+	tokens []predicateToken
+	pos    int
+}
+
+func (p *predicateParser) peek() predicateToken {
+	return p.tokens[p.pos]
+}
+
+func (p *predicateParser) next() predicateToken {
+	token := p.tokens[p.pos]
+	if token.kind != predicateTokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *predicateParser) errorf(token predicateToken, format string, args ...any) error {
+	return &PredicateSyntaxError{Column: token.column, Message: fmt.Sprintf(format, args...)}
+}
+
+// parseOr parses `and (OR and)*`
+func (p *predicateParser) parseOr() error {
+	if err := p.parseAnd(); err != nil {
+		return err
+	}
+
+	for p.peek().isKeyword("OR") || p.peek().isSymbol("||") {
+		p.next()
+		if err := p.parseAnd(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseAnd parses `not (AND not)*`
+func (p *predicateParser) parseAnd() error {
+	if err := p.parseNot(); err != nil {
+		return err
+	}
+
+	for p.peek().isKeyword("AND") || p.peek().isSymbol("&&") {
+		p.next()
+		if err := p.parseNot(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseNot parses `(NOT | !) not | primary`
+func (p *predicateParser) parseNot() error {
+	if p.peek().isKeyword("NOT") || p.peek().isSymbol("!") {
+		p.next()
+		return p.parseNot()
+	}
+
+	return p.parsePrimary()
+}
+
+// parsePrimary parses `( or ) | TRUEPREDICATE | FALSEPREDICATE | comparison`
+func (p *predicateParser) parsePrimary() error {
+	token := p.peek()
+
+	if token.isSymbol("(") {
+		p.next()
+		if err := p.parseOr(); err != nil {
+			return err
+		}
+		if closing := p.next(); !closing.isSymbol(")") {
+			return p.errorf(closing, "expected \")\" to close \"(\" at column %d, but got %s", token.column, closing)
+		}
+		return nil
+	}
+
+	if token.isKeyword("TRUEPREDICATE", "FALSEPREDICATE") {
+		p.next()
+		return nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses `[aggregate] key operator[modifiers] value`
+func (p *predicateParser) parseComparison() error {
+	if p.peek().isKeyword(predicateAggregates...) {
+		p.next()
+	}
+
+	key := p.next()
+	if key.kind != predicateTokenIdent || isPredicateReservedWord(key) {
+		return p.errorf(key, "expected a key, but got %s", key)
+	}
+	if err := p.validateKey(key); err != nil {
+		return err
+	}
+
+	operator := p.next()
+	switch {
+	case operator.isSymbol(predicateSymbolOperators...):
+	case operator.isKeyword(predicateStringOperators...):
+	case operator.isKeyword("IN", "BETWEEN"):
+	default:
+		return p.errorf(operator, "expected an operator after %q, but got %s", key.value, operator)
+	}
+
+	if p.peek().isSymbol("[") {
+		if err := p.parseModifiers(); err != nil {
+			return err
+		}
+	}
+
+	if operator.isKeyword("IN", "BETWEEN") {
+		if p.peek().isSymbol("{") {
+			return p.parseSet(operator)
+		}
+	}
+
+	return p.parseValue()
+}
+
+// parseModifiers parses `[c]`, `[d]`, `[cd]` and so on
+func (p *predicateParser) parseModifiers() error {
+	opening := p.next()
+
+	modifiers := p.next()
+	if modifiers.kind != predicateTokenIdent || strings.Trim(strings.ToLower(modifiers.value), "cdn") != "" {
+		return p.errorf(modifiers, "invalid modifier %s, must be a combination of c, d and n", modifiers)
+	}
+
+	if closing := p.next(); !closing.isSymbol("]") {
+		return p.errorf(closing, "expected \"]\" to close \"[\" at column %d, but got %s", opening.column, closing)
+	}
+
+	return nil
+}
+
+// parseSet parses `{ value (, value)* }`
+func (p *predicateParser) parseSet(operator predicateToken) error {
+	opening := p.next()
+
+	count := 0
+	for !p.peek().isSymbol("}") {
+		if count > 0 {
+			if comma := p.next(); !comma.isSymbol(",") {
+				return p.errorf(comma, "expected \",\" or \"}\" in the set at column %d, but got %s", opening.column, comma)
+			}
+		}
+		if err := p.parseValue(); err != nil {
+			return err
+		}
+		count++
+	}
+	p.next()
+
+	if operator.isKeyword("BETWEEN") && count != 2 {
+		return p.errorf(opening, "BETWEEN requires a set of 2 values, but got %d", count)
+	}
+
+	return nil
+}
+
+// parseValue parses a string, number, literal, constant or key
+func (p *predicateParser) parseValue() error {
+	value := p.next()
+
+	switch value.kind {
+	case predicateTokenString, predicateTokenNumber:
+		return nil
+	case predicateTokenIdent:
+		if value.isKeyword(predicateLiterals...) || slices.Contains(predicateConstants, value.value) {
+			return nil
+		}
+		if isPredicateReservedWord(value) {
+			return p.errorf(value, "expected a value, but got %s", value)
+		}
+		if slices.Contains(predicateKeys, predicateKeyRoot(value.value)) {
+			return nil
+		}
+		for _, word := range slices.Concat(predicateKeys, predicateConstants) {
+			if strings.EqualFold(word, value.value) {
+				return p.errorf(value, "unknown key or constant %q, did you mean %q?", value.value, word)
+			}
+		}
+		return p.errorf(value, "unknown key or constant %q", value.value)
+	default:
+		return p.errorf(value, "expected a value, but got %s", value)
+	}
+}
+
+// validateKey validates the key, or the first component of a key path such as senderImagePath.lastPathComponent.
+func (p *predicateParser) validateKey(key predicateToken) error {
+	root := predicateKeyRoot(key.value)
+	if slices.Contains(predicateKeys, root) {
+		return nil
+	}
+
+	for _, k := range predicateKeys {
+		if strings.EqualFold(k, root) {
+			return p.errorf(key, "unknown key %q, did you mean %q?", root, k)
+		}
+	}
+
+	return p.errorf(key, "unknown key %q", root)
+}
+
+// predicateKeyRoot returns the first component of the key path.
+func predicateKeyRoot(key string) string {
+	root, _, _ := strings.Cut(key, ".")
+	return root
+}
+
+func isPredicateReservedWord(token predicateToken) bool {
+	return token.isKeyword("AND", "OR", "NOT", "IN", "BETWEEN", "TRUEPREDICATE", "FALSEPREDICATE") ||
+		token.isKeyword(predicateStringOperators...) || token.isKeyword(predicateAggregates...)
+}
@@ -0,0 +1,161 @@
+package oslog_collector_test
+
+import (
+	"errors"
+	"testing"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePredicate(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		predicate        string
+		expectErr        bool
+		expectColumn     int
+		expectErrMessage string
+	}{
+		"equality with single quotes": {
+			predicate: "subsystem == 'com.apple.mdns'",
+		},
+		"string operator with modifiers": {
+			predicate: `eventMessage contains[cd] "test"`,
+		},
+		"all string operators": {
+			predicate: `process BEGINSWITH "mDNS" OR processImagePath ENDSWITH[c] "/sshd" OR sender LIKE "lib*" OR eventMessage MATCHES "^error: .*$"`,
+		},
+		"logical operators and parentheses": {
+			predicate: `(subsystem == "com.apple.mdns" && category != "resolver") || NOT (messageType == error OR messageType == fault)`,
+		},
+		"in set": {
+			predicate: `subsystem IN {"com.apple.mdns", 'com.apple.network'} AND processIdentifier in {1, 2}`,
+		},
+		"constants and numbers": {
+			predicate: `eventType == logEvent AND messageType >= 16 AND threadIdentifier != 0x1a2b AND !(signpostType == begin)`,
+		},
+		"escaped quotes": {
+			predicate: `eventMessage CONTAINS "it's a \"quoted\" \\path"`,
+		},
+		"between": {
+			predicate: `processIdentifier BETWEEN {100, 200}`,
+		},
+		"truepredicate": {
+			predicate: `TRUEPREDICATE`,
+		},
+		"key paths": {
+			predicate: `senderImagePath.lastPathComponent == "libsystem_info.dylib" AND processImagePath.lastPathComponent == process`,
+		},
+		"process id": {
+			predicate: `processID == 1`,
+		},
+		"aggregates": {
+			predicate: `ANY category IN {"resolver", "client"} OR NOT ALL subsystem == "com.apple.mdns" OR SOME process == "sshd" OR NONE sender == "libsystem"`,
+		},
+		"unknown key path": {
+			predicate:        `sendr.lastPathComponent == "foo"`,
+			expectErr:        true,
+			expectColumn:     1,
+			expectErrMessage: `unknown key "sendr"`,
+		},
+		"unknown key": {
+			predicate:        `subsystem == "com.apple.mdns" AND proces == "sshd"`,
+			expectErr:        true,
+			expectColumn:     35,
+			expectErrMessage: `unknown key "proces"`,
+		},
+		"unknown key with different case": {
+			predicate:        `Subsystem == "com.apple.mdns"`,
+			expectErr:        true,
+			expectColumn:     1,
+			expectErrMessage: `unknown key "Subsystem", did you mean "subsystem"?`,
+		},
+		"unknown constant": {
+			predicate:        `messageType == warning`,
+			expectErr:        true,
+			expectColumn:     16,
+			expectErrMessage: `unknown key or constant "warning"`,
+		},
+		"constant with different case": {
+			predicate:        `messageType == Error`,
+			expectErr:        true,
+			expectColumn:     16,
+			expectErrMessage: `unknown key or constant "Error", did you mean "error"?`,
+		},
+		"missing operator": {
+			predicate:        `subsystem "com.apple.mdns"`,
+			expectErr:        true,
+			expectColumn:     11,
+			expectErrMessage: `expected an operator after "subsystem", but got string`,
+		},
+		"invalid modifier": {
+			predicate:        `eventMessage CONTAINS[x] "test"`,
+			expectErr:        true,
+			expectColumn:     23,
+			expectErrMessage: `invalid modifier "x"`,
+		},
+		"unterminated string": {
+			predicate:        `subsystem == 'com.apple.mdns`,
+			expectErr:        true,
+			expectColumn:     14,
+			expectErrMessage: "unterminated string",
+		},
+		"unclosed parenthesis": {
+			predicate:        `(subsystem == "com.apple.mdns"`,
+			expectErr:        true,
+			expectColumn:     31,
+			expectErrMessage: `expected ")" to close "(" at column 1, but got end of predicate`,
+		},
+		"dangling operator": {
+			predicate:        `subsystem == "com.apple.mdns" AND`,
+			expectErr:        true,
+			expectColumn:     34,
+			expectErrMessage: "expected a key, but got end of predicate",
+		},
+		"trailing token": {
+			predicate:        `subsystem == "com.apple.mdns" "com.apple.network"`,
+			expectErr:        true,
+			expectColumn:     31,
+			expectErrMessage: "unexpected string",
+		},
+		"unterminated set": {
+			predicate:        `subsystem IN {"a" "b"}`,
+			expectErr:        true,
+			expectColumn:     19,
+			expectErrMessage: `expected "," or "}" in the set at column 14, but got string`,
+		},
+		"between with invalid set": {
+			predicate:        `processIdentifier BETWEEN {100}`,
+			expectErr:        true,
+			expectColumn:     27,
+			expectErrMessage: "BETWEEN requires a set of 2 values, but got 1",
+		},
+		"unexpected character": {
+			predicate:        `subsystem == "a" ; process == "b"`,
+			expectErr:        true,
+			expectColumn:     18,
+			expectErrMessage: `unexpected character ';'`,
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := oslog_collector.ValidatePredicate(tt.predicate)
+			if tt.expectErr {
+				var syntaxErr *oslog_collector.PredicateSyntaxError
+				if assert.True(t, errors.As(err, &syntaxErr)) {
+					assert.Equal(t, tt.expectColumn, syntaxErr.Column)
+					assert.Contains(t, syntaxErr.Message, tt.expectErrMessage)
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package oslog_collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// configDiff is the difference between the old and new configs of collectors or archive watchers, keyed by name.
+type configDiff struct {
+	Added     []string
+	Removed   []string
+	Changed   []string
+	Unchanged []string
+	// ChangedFields maps the name of a changed collector to the YAML keys of the changed settings
+	ChangedFields map[string][]string
+}
+
+func (d configDiff) isEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// diffConfigs compares the old and new configs by name.
+func diffConfigs[T any](oldConfigs, newConfigs []T, name func(T) string) configDiff {
+	diff := configDiff{ChangedFields: map[string][]string{}}
+
+	olds := make(map[string]T, len(oldConfigs))
+	for _, c := range oldConfigs {
+		olds[name(c)] = c
+	}
+
+	news := make(map[string]struct{}, len(newConfigs))
+	for _, c := range newConfigs {
+		n := name(c)
+		news[n] = struct{}{}
+
+		old, ok := olds[n]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, n)
+		case reflect.DeepEqual(old, c):
+			diff.Unchanged = append(diff.Unchanged, n)
+		default:
+			diff.Changed = append(diff.Changed, n)
+			diff.ChangedFields[n] = changedFields(old, c)
+		}
+	}
+
+	for _, c := range oldConfigs {
+		if _, ok := news[name(c)]; !ok {
+			diff.Removed = append(diff.Removed, name(c))
+		}
+	}
+
+	return diff
+}
+
+// changedFields returns the YAML keys of the fields that differ between the old and new configs.
+func changedFields[T any](oldConfig, newConfig T) []string {
+	oldValue := reflect.ValueOf(oldConfig)
+	newValue := reflect.ValueOf(newConfig)
+
+	var fields []string
+	for i := 0; i < oldValue.NumField(); i++ {
+		if reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			continue
+		}
+
+		field := oldValue.Type().Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "" {
+			key = field.Name
+		}
+		fields = append(fields, key)
+	}
+
+	return fields
+}
+
+// Reload loads the config file again and applies the difference to the running collectors and archive watchers.
+// Added ones are started, removed ones are stopped, and changed ones are restarted. The running log command of
+// a stopped collector is terminated, and its window is collected again because the position is not saved.
+// Positions are kept because a restarted collector loads the position file saved by the stopped one.
+// If the new config is invalid, the running config is kept, and so is the config of a collector that cannot be
+// created or does not stop within the shutdown timeout.
+// The removed and changed ones are stopped together within one shutdown timeout, without holding a.mu, so that
+// the status and the other collectors are not blocked while waiting.
+func (a *Agent) Reload(ctx context.Context) error {
+	if a.ConfigFilePath == "" {
+		return fmt.Errorf("config file path is not set")
+	}
+
+	newConfig, err := LoadConfigFromFile(a.ConfigFilePath)
+	if err != nil {
+		return err
+	}
+
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	a.mu.Lock()
+	collectorDiff := diffConfigs(a.Config.Collectors, newConfig.Collectors, collectorReloadKind.name)
+	watcherDiff := diffConfigs(a.Config.ArchiveWatchers, newConfig.ArchiveWatchers, archiveWatcherReloadKind.name)
+	logConfigDiff(collectorReloadKind.noun, collectorDiff)
+	logConfigDiff(archiveWatcherReloadKind.noun, watcherDiff)
+
+	tasks := a.cancelTasks(slices.Concat(collectorReloadKind.stoppedTaskKeys(collectorDiff), archiveWatcherReloadKind.stoppedTaskKeys(watcherDiff)))
+	a.mu.Unlock()
+
+	stuck := waitTasks(tasks, a.shutdownTimeout())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.forgetTasks(tasks, stuck)
+
+	if newConfig.PIDFile != a.Config.PIDFile {
+		slog.Warn("pid_file cannot be changed without restarting the agent", "pid_file", a.Config.PIDFile, "new_pid_file", newConfig.PIDFile)
+		newConfig.PIDFile = a.Config.PIDFile
+	}
+	if newConfig.ControlSocket != a.Config.ControlSocket {
+		slog.Warn("control_socket cannot be changed without restarting the agent", "control_socket", a.Config.ControlSocket, "new_control_socket", newConfig.ControlSocket)
+		newConfig.ControlSocket = a.Config.ControlSocket
+	}
+	if !reflect.DeepEqual(newConfig.Log, a.Config.Log) {
+		slog.Warn("log cannot be changed without restarting the agent")
+		newConfig.Log = a.Config.Log
+	}
+	if !reflect.DeepEqual(newConfig.HTTP, a.Config.HTTP) {
+		slog.Warn("http cannot be changed without restarting the agent")
+		newConfig.HTTP = a.Config.HTTP
+	}
+	if newConfig.MaxConcurrentCommands != a.Config.MaxConcurrentCommands {
+		slog.Warn("max_concurrent_commands cannot be changed without restarting the agent",
+			"max_concurrent_commands", a.Config.MaxConcurrentCommands, "new_max_concurrent_commands", newConfig.MaxConcurrentCommands)
+		newConfig.MaxConcurrentCommands = a.Config.MaxConcurrentCommands
+	}
+
+	a.LogCollectors, newConfig.Collectors = reloadTasks(ctx, a, a.collectorKind(), a.LogCollectors,
+		a.Config.Collectors, newConfig.Collectors, collectorDiff, stuck)
+	a.ArchiveWatchers, newConfig.ArchiveWatchers = reloadTasks(ctx, a, a.archiveWatcherKind(), a.ArchiveWatchers,
+		a.Config.ArchiveWatchers, newConfig.ArchiveWatchers, watcherDiff, stuck)
+	a.Config = newConfig
+
+	return nil
+}
+
+// reloadable is a collector or an archive watcher run by the agent, which Reload starts, stops and replaces.
+type reloadable interface {
+	taskKey() string
+	run(ctx context.Context)
+	taskLogger() *slog.Logger
+	// lockedFile is the position file or the state file locked while it is open
+	lockedFile() string
+	// heldLock returns the lock of lockedFile, and handOverLock forgets it without releasing it,
+	// after it is handed over to the one replacing it
+	heldLock() *fileLock
+	handOverLock()
+	Close() error
+}
+
+// reloadKind tells reloadTasks how to create and remove the collectors or the archive watchers of the configs of type C.
+type reloadKind[T reloadable, C any] struct {
+	// noun is "collector" or "archive watcher" in the logs and the errors
+	noun       string
+	name       func(C) string
+	taskKey    func(name string) string
+	lockedFile func(C) string
+	// create creates a new one with the config, taking over the lock of lockedFile if lock is not nil
+	create        func(config C, lock *fileLock) (T, error)
+	removeMetrics func(name string)
+}
+
+var collectorReloadKind = reloadKind[*OSLogCollector, OSLogCollectorConfig]{
+	noun:       "collector",
+	name:       func(c OSLogCollectorConfig) string { return c.Name },
+	taskKey:    collectorTaskKey,
+	lockedFile: func(c OSLogCollectorConfig) string { return c.PositionFile },
+}
+
+var archiveWatcherReloadKind = reloadKind[*ArchiveWatcher, ArchiveWatcherConfig]{
+	noun:       "archive watcher",
+	name:       func(c ArchiveWatcherConfig) string { return c.Name },
+	taskKey:    archiveWatcherTaskKey,
+	lockedFile: func(c ArchiveWatcherConfig) string { return c.StateFile },
+}
+
+// stoppedTaskKeys returns the task keys of the removed and changed ones, which are stopped by the reload.
+func (k reloadKind[T, C]) stoppedTaskKeys(diff configDiff) []string {
+	var keys []string
+	for _, name := range slices.Concat(diff.Removed, diff.Changed) {
+		keys = append(keys, k.taskKey(name))
+	}
+	return keys
+}
+
+// collectorKind returns collectorReloadKind creating the collectors with the options of the agent.
+func (a *Agent) collectorKind() reloadKind[*OSLogCollector, OSLogCollectorConfig] {
+	kind := collectorReloadKind
+	kind.create = func(config OSLogCollectorConfig, lock *fileLock) (*OSLogCollector, error) {
+		opts := a.collectorOptions
+		if lock != nil {
+			opts = append(slices.Clone(opts), withPositionLock(lock))
+		}
+		return NewOSLogCollector(config, opts...)
+	}
+	kind.removeMetrics = a.Metrics.removeCollector
+	return kind
+}
+
+// archiveWatcherKind returns archiveWatcherReloadKind creating the archive watchers with the options of the agent.
+func (a *Agent) archiveWatcherKind() reloadKind[*ArchiveWatcher, ArchiveWatcherConfig] {
+	kind := archiveWatcherReloadKind
+	kind.create = func(config ArchiveWatcherConfig, lock *fileLock) (*ArchiveWatcher, error) {
+		opts := a.archiveWatcherOptions
+		if lock != nil {
+			opts = append(slices.Clone(opts), withStateLock(lock))
+		}
+		return NewArchiveWatcher(config, opts...)
+	}
+	kind.removeMetrics = a.Metrics.removeArchiveWatcher
+	return kind
+}
+
+// reloadTasks applies the new configs to the running collectors or archive watchers, and returns the running ones and
+// their configs. The caller must hold a.mu, and the removed and changed ones must have been stopped except the stuck ones.
+// A changed one is replaced only if the new one is created, and one that did not stop within the shutdown timeout
+// is kept with its running config until the next reload.
+func reloadTasks[T reloadable, C any](ctx context.Context, a *Agent, kind reloadKind[T, C], running []T, oldConfigs, newConfigs []C, diff configDiff, stuck map[string]struct{}) ([]T, []C) {
+	tasks := make(map[string]T, len(running))
+	for _, task := range running {
+		tasks[task.taskKey()] = task
+	}
+	olds := make(map[string]C, len(oldConfigs))
+	for _, config := range oldConfigs {
+		olds[kind.name(config)] = config
+	}
+
+	appliedConfigs := make([]C, 0, len(newConfigs))
+	applied := make([]T, 0, len(newConfigs))
+
+	for _, name := range diff.Removed {
+		task := tasks[kind.taskKey(name)]
+		if _, ok := stuck[kind.taskKey(name)]; ok {
+			task.taskLogger().Error(capitalize(kind.noun) + " did not stop within the shutdown timeout, keeping it until the next reload")
+			appliedConfigs = append(appliedConfigs, olds[name])
+			applied = append(applied, task)
+			continue
+		}
+		closeTasks(kind.noun, []T{task})
+		kind.removeMetrics(name)
+	}
+
+	for _, config := range newConfigs {
+		name := kind.name(config)
+		task, ok := tasks[kind.taskKey(name)]
+		switch {
+		case !ok:
+			var err error
+			task, err = kind.create(config, nil)
+			if err != nil {
+				// it is retried on the next reload because it is not in the applied config
+				slog.Error("Error creating "+kind.noun, "collector_name", name, "error", err)
+				continue
+			}
+			a.start(ctx, task)
+		case slices.Contains(diff.Changed, name):
+			_, isStuck := stuck[kind.taskKey(name)]
+			replaced, err := replaceTask(ctx, a, kind, task, config, isStuck)
+			if err != nil {
+				task.taskLogger().Error("Error replacing "+kind.noun+", keeping the running config until the next reload", "error", err)
+				config = olds[name]
+			} else {
+				task = replaced
+			}
+		case !a.taskRunning(kind.taskKey(name)):
+			// it stopped after it was kept on a previous reload
+			a.start(ctx, task)
+		}
+
+		appliedConfigs = append(appliedConfigs, config)
+		applied = append(applied, task)
+	}
+
+	return applied, appliedConfigs
+}
+
+// replaceTask starts a new collector or archive watcher with the config in place of the stopped one, taking over
+// the lock of the position file or the state file if it is not changed. If the new one cannot be created,
+// the stopped one is started again. The caller must hold a.mu.
+func replaceTask[T reloadable, C any](ctx context.Context, a *Agent, kind reloadKind[T, C], old T, config C, stuck bool) (T, error) {
+	var replaced T
+	if stuck {
+		return replaced, fmt.Errorf("%s did not stop within the shutdown timeout", kind.noun)
+	}
+
+	sameFile := kind.lockedFile(config) == old.lockedFile()
+	var lock *fileLock
+	if sameFile {
+		lock = old.heldLock()
+	}
+
+	replaced, err := kind.create(config, lock)
+	if err != nil {
+		a.start(ctx, old)
+		return replaced, err
+	}
+
+	if sameFile {
+		old.handOverLock()
+	}
+	closeTasks(kind.noun, []T{old})
+
+	a.start(ctx, replaced)
+	return replaced, nil
+}
+
+// closeTasks closes the collectors or the archive watchers that are not running.
+func closeTasks[T reloadable](noun string, tasks []T) {
+	for _, task := range tasks {
+		if err := task.Close(); err != nil {
+			task.taskLogger().Error("Error closing "+noun, "error", err)
+		}
+	}
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func logConfigDiff(kind string, diff configDiff) {
+	if diff.isEmpty() {
+		slog.Info("Reloaded config, no "+kind+" changed", "unchanged", diff.Unchanged)
+		return
+	}
+
+	slog.Info("Reloaded config, applying "+kind+" changes",
+		"added", diff.Added, "removed", diff.Removed, "changed", diff.Changed, "unchanged", diff.Unchanged)
+
+	for _, name := range diff.Changed {
+		slog.Info("Restarting "+kind+" with changed settings", "collector_name", name, "changed_settings", diff.ChangedFields[name])
+	}
+}
@@ -0,0 +1,141 @@
+package oslog_collector
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+const (
+	defaultProbeInterval = 300
+
+	defaultRetryBaseDelay = 1
+	defaultRetryMaxDelay  = 300
+)
+
+// Delay returns the delay before the retry after the attempt (1-based) failed, which doubles from BaseDelay
+// up to MaxDelay and is randomized by ±Jitter of itself.
+// BaseDelay defaults to 1 second, and the delay is capped at 300 seconds or BaseDelay if MaxDelay is not set,
+// so that the doubling does not overflow with many attempts.
+func (r RetryConfig) Delay(attempt int) time.Duration {
+	baseDelay := r.BaseDelay
+	if baseDelay == 0 {
+		baseDelay = defaultRetryBaseDelay
+	}
+	maxDelay := r.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = max(defaultRetryMaxDelay, baseDelay)
+	}
+
+	delay := time.Duration(baseDelay) * time.Second
+	for i := 1; i < attempt && delay < time.Duration(maxDelay)*time.Second; i++ {
+		delay *= 2
+	}
+	delay = min(delay, time.Duration(maxDelay)*time.Second)
+
+	if r.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + r.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+func validateRetry(r *RetryConfig) error {
+	if r == nil {
+		return nil
+	}
+
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("retry: max_attempts must not be negative")
+	}
+	if r.BaseDelay < 0 || r.MaxDelay < 0 {
+		return fmt.Errorf("retry: base_delay and max_delay must not be negative")
+	}
+	if r.MaxDelay > 0 && r.MaxDelay < r.BaseDelay {
+		return fmt.Errorf("retry: max_delay must be greater than or equal to base_delay")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("retry: jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+func validateCircuitBreaker(cb *CircuitBreakerConfig) error {
+	if cb == nil {
+		return nil
+	}
+
+	if cb.FailureThreshold < 0 {
+		return fmt.Errorf("circuit_breaker: failure_threshold must not be negative")
+	}
+	if cb.ProbeInterval < 0 {
+		return fmt.Errorf("circuit_breaker: probe_interval must not be negative")
+	}
+	return nil
+}
+
+// collectWithRetry runs CollectLogs up to Retry.MaxAttempts times within a cycle, waiting for the backoff between
+// the attempts, and updates the circuit breaker with the result of the cycle.
+func (c *OSLogCollector) collectWithRetry(ctx context.Context) error {
+	maxAttempts := max(c.Retry.MaxAttempts, 1)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = c.CollectLogs(ctx)
+		if err == nil || attempt >= maxAttempts || ctx.Err() != nil {
+			break
+		}
+
+		delay := c.Retry.Delay(attempt)
+		c.logger.Warn("Error collecting logs, retrying", "attempt", attempt, "max_attempts", maxAttempts,
+			"delay", delay, "error_class", errorClass(err), "error", err)
+		c.metrics.observeRetry(c.Name)
+
+		timer := flextime.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+
+	if ctx.Err() == nil {
+		c.recordCycle(err)
+	}
+	return err
+}
+
+// recordCycle counts the consecutive failed cycles, and opens the circuit breaker when they reach the threshold.
+// The collector is degraded while the circuit breaker is open, and recovers on the next successful cycle.
+func (c *OSLogCollector) recordCycle(err error) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	if err == nil {
+		if c.degraded {
+			c.logger.Info("Collector recovered", "failures", c.consecutiveFailures)
+		}
+		c.consecutiveFailures = 0
+		c.degraded = false
+		c.metrics.setDegraded(c.Name, false)
+		return
+	}
+
+	c.consecutiveFailures++
+	if threshold := c.CircuitBreaker.FailureThreshold; threshold > 0 && c.consecutiveFailures >= threshold && !c.degraded {
+		c.degraded = true
+		c.logger.Error("Collector degraded, probing at the slower interval until it recovers",
+			"failures", c.consecutiveFailures, "probe_interval", c.probeInterval())
+		c.metrics.setDegraded(c.Name, true)
+	}
+}
+
+func (c *OSLogCollector) probeInterval() time.Duration {
+	if c.CircuitBreaker.ProbeInterval == 0 {
+		return defaultProbeInterval * time.Second
+	}
+	return time.Duration(c.CircuitBreaker.ProbeInterval) * time.Second
+}
@@ -0,0 +1,165 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryConfig_Delay(t *testing.T) {
+	testCases := map[string]struct {
+		retry    oslog_collector.RetryConfig
+		attempt  int
+		expected time.Duration
+	}{
+		"first retry waits base_delay": {
+			retry:    oslog_collector.RetryConfig{BaseDelay: 2, MaxDelay: 30},
+			attempt:  1,
+			expected: 2 * time.Second,
+		},
+		"delay doubles on each retry": {
+			retry:    oslog_collector.RetryConfig{BaseDelay: 2, MaxDelay: 30},
+			attempt:  3,
+			expected: 8 * time.Second,
+		},
+		"delay is capped at max_delay": {
+			retry:    oslog_collector.RetryConfig{BaseDelay: 2, MaxDelay: 30},
+			attempt:  10,
+			expected: 30 * time.Second,
+		},
+		"base_delay defaults to 1 second": {
+			retry:    oslog_collector.RetryConfig{MaxAttempts: 3},
+			attempt:  2,
+			expected: 2 * time.Second,
+		},
+		"delay doubles up to 300 seconds without max_delay": {
+			retry:    oslog_collector.RetryConfig{BaseDelay: 1},
+			attempt:  7,
+			expected: 64 * time.Second,
+		},
+		"delay is capped at 300 seconds without max_delay": {
+			retry:    oslog_collector.RetryConfig{BaseDelay: 1},
+			attempt:  100,
+			expected: 300 * time.Second,
+		},
+		"delay is capped at base_delay greater than 300 seconds without max_delay": {
+			retry:    oslog_collector.RetryConfig{BaseDelay: 600},
+			attempt:  3,
+			expected: 600 * time.Second,
+		},
+	}
+
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.retry.Delay(tt.attempt))
+		})
+	}
+
+	t.Run("jitter randomizes delay within the fraction", func(t *testing.T) {
+		retry := oslog_collector.RetryConfig{BaseDelay: 10, Jitter: 0.2}
+		for i := 0; i < 100; i++ {
+			delay := retry.Delay(1)
+			assert.GreaterOrEqual(t, delay, 8*time.Second)
+			assert.LessOrEqual(t, delay, 12*time.Second)
+		}
+	})
+}
+
+func TestRunLogCollector_RetryAndCircuitBreaker(t *testing.T) {
+	clock := newManualClock(t, time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC))
+
+	workdir := t.TempDir()
+	cfg := oslog_collector.OSLogCollectorConfig{
+		Name:           "test",
+		Predicate:      "process == 'test'",
+		OutputFile:     filepath.Join(workdir, "test.log"),
+		PositionFile:   filepath.Join(workdir, "test.pos"),
+		Interval:       60,
+		Splay:          new(int), // 0 to run the first collection immediately
+		Retry:          &oslog_collector.RetryConfig{MaxAttempts: 3},
+		CircuitBreaker: &oslog_collector.CircuitBreakerConfig{FailureThreshold: 2, ProbeInterval: 10},
+	}
+
+	writePastPosition(t, cfg.PositionFile)
+
+	// the runner counts the runs and fails while failing is true
+	var failing atomic.Bool
+	var runs atomic.Int32
+	failing.Store(true)
+
+	metrics := oslog_collector.NewMetrics()
+	collector, err := oslog_collector.NewOSLogCollector(cfg,
+		oslog_collector.WithMetrics(metrics),
+		oslog_collector.WithMonotonicClock(clock.Monotonic),
+		oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+			return runnerFunc(func(ctx context.Context) ([]byte, error) {
+				runs.Add(1)
+				if failing.Load() {
+					return nil, errors.New("exit status 1")
+				}
+				return []byte("{}\n"), nil
+			})
+		}))
+	require.NoError(t, err)
+	defer collector.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		oslog_collector.StartLogCollectors(ctx, []*oslog_collector.OSLogCollector{collector})
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		clock.Close()
+		<-done
+	}()
+
+	// advance waits for the next timer of the collector and advances the clock to it
+	advance := func(d time.Duration) {
+		clock.WaitForTimers(t, 1)
+		clock.Advance(d)
+	}
+
+	// the first cycle fails after 3 attempts waiting for base_delay of 1 second and then 2 seconds
+	advance(time.Second)
+	advance(2 * time.Second)
+	require.Eventually(t, func() bool { return collector.Status(3).ConsecutiveFailures == 1 }, 5*time.Second, time.Millisecond)
+	assert.False(t, collector.Status(3).Degraded)
+	assert.Equal(t, int32(3), runs.Load())
+
+	// the second cycle at the interval opens the circuit breaker
+	advance(60 * time.Second)
+	advance(time.Second)
+	advance(2 * time.Second)
+	require.Eventually(t, func() bool { return collector.Status(3).Degraded }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, int32(6), runs.Load())
+
+	var buf strings.Builder
+	_, err = metrics.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), `oslog_collector_degraded{collector="test"} 1`+"\n")
+	assert.Contains(t, buf.String(), `oslog_collector_retries_total{collector="test"} 4`+"\n")
+
+	// the degraded collector probes at the probe interval instead of the interval, and recovers on success
+	failing.Store(false)
+	advance(9 * time.Second)
+	assert.Equal(t, int32(6), runs.Load(), "the collector does not probe before the probe interval")
+	clock.Advance(time.Second)
+	require.Eventually(t, func() bool { return !collector.Status(3).Degraded }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, 0, collector.Status(3).ConsecutiveFailures)
+	assert.Equal(t, int32(8), runs.Load(), "the probe collects the failed window again, and then the window up to now")
+
+	buf.Reset()
+	_, err = metrics.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), `oslog_collector_degraded{collector="test"} 0`+"\n")
+}
@@ -0,0 +1,308 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// Schedule decides when a collector runs.
+type Schedule interface {
+	// Next returns the time of the next run after now.
+	Next(now time.Time) time.Time
+}
+
+// intervalSchedule runs every interval after the previous run, or at the multiples of the interval since midnight
+// on the wall clock if align is true.
+type intervalSchedule struct {
+	interval time.Duration
+	align    bool
+}
+
+// NewIntervalSchedule returns the schedule that runs every interval. If align is true, it runs at the multiples of
+// the interval since midnight, e.g. at :00, :05, :10 and so on for 5 minutes, instead of every interval after now.
+func NewIntervalSchedule(interval time.Duration, align bool) Schedule {
+	return &intervalSchedule{interval: interval, align: align}
+}
+
+func (s *intervalSchedule) Next(now time.Time) time.Time {
+	if !s.align {
+		return now.Add(s.interval)
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return midnight.Add((now.Sub(midnight)/s.interval + 1) * s.interval)
+}
+
+// maxCronSearchYears is how far Next looks for a matching time, beyond which the expression never matches.
+const maxCronSearchYears = 5
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	cronDayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// cronSchedule runs at the times matching a cron expression in the local time of now.
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// dayOfMonthAny and dayOfWeekAny are true if the fields are *, in which case a day matches if the other one matches
+	dayOfMonthAny, dayOfWeekAny bool
+}
+
+// ParseCronSchedule parses a cron expression of the five fields: minute, hour, day of month, month and day of week.
+// Each field is *, a value, a range a-b, or a list of them separated by commas, optionally followed by /step.
+// Months and days of week can be given by the first three letters of their names, and @hourly, @daily, @weekly,
+// @monthly and @yearly are accepted.
+func ParseCronSchedule(expr string) (Schedule, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, but got %d", expr, len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: minute: %v", expr, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: hour: %v", expr, err)
+	}
+	if s.dayOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of month: %v", expr, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: month: %v", expr, err)
+	}
+	if s.dayOfWeek, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of week: %v", expr, err)
+	}
+	// 7 is also Sunday
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek |= 1
+	}
+	s.dayOfMonthAny = strings.HasPrefix(fields[2], "*")
+	s.dayOfWeekAny = strings.HasPrefix(fields[4], "*")
+
+	if s.Next(flextime.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: it never matches", expr)
+	}
+
+	return &s, nil
+}
+
+// parseCronField parses a field of a cron expression to the bit set of the matching values.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			low, high, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			if start, err = parseCronValue(low, min, max, names); err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseCronValue(high, min, max, names); err != nil {
+					return 0, err
+				}
+				if end < start {
+					return 0, fmt.Errorf("invalid range %q", rangePart)
+				}
+			} else if hasStep {
+				// a/n means from a to the maximum every n
+				end = max
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(value)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// Next returns the first matching minute after now, or the zero time if nothing matches within maxCronSearchYears.
+func (s *cronSchedule) Next(now time.Time) time.Time {
+	loc := now.Location()
+	t := now.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxCronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay matches the day of month and the day of week. If both are restricted, a day matches if either matches.
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	dayOfMonth := s.dayOfMonth&(1<<t.Day()) != 0
+	dayOfWeek := s.dayOfWeek&(1<<int(t.Weekday())) != 0
+
+	if s.dayOfMonthAny || s.dayOfWeekAny {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// newSchedule returns the schedule of the collector config, which is the cron schedule if schedule is set,
+// and the interval schedule otherwise.
+func newSchedule(config OSLogCollectorConfig) (Schedule, error) {
+	if config.Schedule != "" {
+		return ParseCronSchedule(config.Schedule)
+	}
+	return NewIntervalSchedule(time.Duration(config.Interval)*time.Second, config.Align), nil
+}
+
+func validateSchedulingOptions(c OSLogCollectorConfig) error {
+	if c.Schedule != "" {
+		if c.Interval != 0 {
+			return fmt.Errorf("interval and schedule are mutually exclusive")
+		}
+		if c.Align {
+			return fmt.Errorf("align is only for interval, schedule is always aligned")
+		}
+		if _, err := ParseCronSchedule(c.Schedule); err != nil {
+			return err
+		}
+	} else if err := validateInterval(c.Interval); err != nil {
+		return err
+	}
+
+	if c.Splay != nil && *c.Splay < 0 {
+		return fmt.Errorf("splay must not be negative")
+	}
+	if c.IntervalJitter < 0 || c.IntervalJitter >= 1 {
+		return fmt.Errorf("interval_jitter must be greater than or equal to 0 and less than 1")
+	}
+	return nil
+}
+
+// scheduled returns true if the collector runs at the times of the schedule instead of every interval after the previous run.
+func (c *OSLogCollector) scheduled() bool {
+	return c.Schedule != "" || c.Align
+}
+
+// defaultSplayFraction is the splay of a collector that does not set it, as the fraction of the interval
+const defaultSplayFraction = 0.1
+
+// splay returns the maximum random offset of the first collection, which is defaultSplayFraction of the interval
+// by default. A collector with a schedule or aligned has no default splay, so that it runs at the times of the schedule.
+func splay(config OSLogCollectorConfig) time.Duration {
+	if config.Splay != nil {
+		return time.Duration(*config.Splay) * time.Second
+	}
+	if config.Schedule != "" || config.Align {
+		return 0
+	}
+	return time.Duration(float64(config.Interval) * defaultSplayFraction * float64(time.Second))
+}
+
+// initialDelay returns the delay of the first collection, which is the time until the next run of the schedule
+// if the collector is scheduled, or 0 otherwise, plus the random offset up to Splay.
+func (c *OSLogCollector) initialDelay() time.Duration {
+	var delay time.Duration
+	if c.scheduled() {
+		now := flextime.Now()
+		delay = c.schedule.Next(now).Sub(now)
+	}
+
+	if c.Splay > 0 {
+		delay += rand.N(c.Splay)
+	}
+	return delay
+}
+
+// nextInterval returns the delay until the next cycle, which is the current interval that may be adaptive,
+// or the probe interval while the collector is degraded.
+// The interval and the probe interval are randomized by IntervalJitter, but the schedule is not.
+func (c *OSLogCollector) nextInterval() time.Duration {
+	c.statusMu.Lock()
+	degraded, interval := c.degraded, c.currentInterval
+	c.statusMu.Unlock()
+
+	if degraded {
+		return c.jitter(c.probeInterval())
+	}
+	if !c.scheduled() {
+		return c.jitter(interval)
+	}
+
+	now := flextime.Now()
+	return c.schedule.Next(now).Sub(now)
+}
+
+// period returns the time between two runs of the collector, which is used to judge whether it is ready.
+// The caller must hold c.statusMu.
+func (c *OSLogCollector) period(now time.Time) time.Duration {
+	if c.Schedule == "" || c.schedule == nil {
+		return c.currentInterval
+	}
+
+	next := c.schedule.Next(now)
+	return c.schedule.Next(next).Sub(next)
+}
+
+// jitter randomizes the interval by ±IntervalJitter of itself.
+func (c *OSLogCollector) jitter(interval time.Duration) time.Duration {
+	if c.IntervalJitter <= 0 {
+		return interval
+	}
+	return time.Duration(float64(interval) * (1 + c.IntervalJitter*(2*rand.Float64()-1)))
+}
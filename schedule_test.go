@@ -0,0 +1,266 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	now := time.Date(2025, 1, 29, 10, 7, 30, 0, jst) // Wednesday
+
+	testCases := map[string]struct {
+		expr     string
+		expected []time.Time
+	}{
+		"every 5 minutes": {
+			expr: "*/5 * * * *",
+			expected: []time.Time{
+				time.Date(2025, 1, 29, 10, 10, 0, 0, jst),
+				time.Date(2025, 1, 29, 10, 15, 0, 0, jst),
+			},
+		},
+		"list and range of hours": {
+			expr: "30 9-10,17 * * *",
+			expected: []time.Time{
+				time.Date(2025, 1, 29, 10, 30, 0, 0, jst),
+				time.Date(2025, 1, 29, 17, 30, 0, 0, jst),
+				time.Date(2025, 1, 30, 9, 30, 0, 0, jst),
+			},
+		},
+		"days of week by names": {
+			expr: "0 9 * * mon-fri",
+			expected: []time.Time{
+				time.Date(2025, 1, 30, 9, 0, 0, 0, jst),
+				time.Date(2025, 1, 31, 9, 0, 0, 0, jst),
+				time.Date(2025, 2, 3, 9, 0, 0, 0, jst),
+			},
+		},
+		"either day of month or day of week matches if both are restricted": {
+			expr: "0 0 1 * 0",
+			expected: []time.Time{
+				time.Date(2025, 2, 1, 0, 0, 0, 0, jst),
+				time.Date(2025, 2, 2, 0, 0, 0, 0, jst),
+				time.Date(2025, 2, 9, 0, 0, 0, 0, jst),
+			},
+		},
+		"macro": {
+			expr: "@monthly",
+			expected: []time.Time{
+				time.Date(2025, 2, 1, 0, 0, 0, 0, jst),
+				time.Date(2025, 3, 1, 0, 0, 0, 0, jst),
+			},
+		},
+		"leap day": {
+			expr: "0 0 29 2 *",
+			expected: []time.Time{
+				time.Date(2028, 2, 29, 0, 0, 0, 0, jst),
+			},
+		},
+	}
+
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			schedule, err := oslog_collector.ParseCronSchedule(tt.expr)
+			require.NoError(t, err)
+
+			next := now
+			for _, expected := range tt.expected {
+				next = schedule.Next(next)
+				assert.Equal(t, expected, next)
+			}
+		})
+	}
+}
+
+func TestParseCronSchedule_Error(t *testing.T) {
+	testCases := map[string]struct {
+		expr     string
+		expected string
+	}{
+		"too few fields": {
+			expr:     "*/5 * * *",
+			expected: `invalid schedule "*/5 * * *": expected 5 fields, but got 4`,
+		},
+		"value out of range": {
+			expr:     "60 * * * *",
+			expected: `invalid schedule "60 * * * *": minute: value 60 out of range 0-59`,
+		},
+		"invalid step": {
+			expr:     "*/0 * * * *",
+			expected: `invalid schedule "*/0 * * * *": minute: invalid step "0"`,
+		},
+		"invalid range": {
+			expr:     "0 17-9 * * *",
+			expected: `invalid schedule "0 17-9 * * *": hour: invalid range "17-9"`,
+		},
+		"never matches": {
+			expr:     "0 0 30 feb *",
+			expected: `invalid schedule "0 0 30 feb *": it never matches`,
+		},
+	}
+
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := oslog_collector.ParseCronSchedule(tt.expr)
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func TestIntervalSchedule(t *testing.T) {
+	now := time.Date(2025, 1, 29, 10, 7, 30, 0, time.UTC)
+
+	t.Run("when not aligned then run every interval after now", func(t *testing.T) {
+		schedule := oslog_collector.NewIntervalSchedule(5*time.Minute, false)
+		assert.Equal(t, time.Date(2025, 1, 29, 10, 12, 30, 0, time.UTC), schedule.Next(now))
+	})
+
+	t.Run("when aligned then run at the multiples of interval on the wall clock", func(t *testing.T) {
+		schedule := oslog_collector.NewIntervalSchedule(5*time.Minute, true)
+		assert.Equal(t, time.Date(2025, 1, 29, 10, 10, 0, 0, time.UTC), schedule.Next(now))
+		assert.Equal(t, time.Date(2025, 1, 29, 10, 15, 0, 0, time.UTC), schedule.Next(time.Date(2025, 1, 29, 10, 10, 0, 0, time.UTC)))
+	})
+
+	t.Run("when aligned with a zone of half an hour offset then align to the local midnight", func(t *testing.T) {
+		ist := time.FixedZone("IST", 5*60*60+30*60)
+		schedule := oslog_collector.NewIntervalSchedule(time.Hour, true)
+		assert.Equal(t, time.Date(2025, 1, 29, 11, 0, 0, 0, ist), schedule.Next(time.Date(2025, 1, 29, 10, 7, 30, 0, ist)))
+	})
+}
+
+func TestNewOSLogCollector_Splay(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		settings    string
+		expectSplay time.Duration
+	}{
+		"when splay is not set then default to 10% of the interval": {
+			settings:    "interval: 60",
+			expectSplay: 6 * time.Second,
+		},
+		"when splay is 0 then disable it": {
+			settings:    "interval: 60\n    splay: 0",
+			expectSplay: 0,
+		},
+		"when splay is set then use it": {
+			settings:    "interval: 60\n    splay: 30",
+			expectSplay: 30 * time.Second,
+		},
+		"when collector is aligned then have no default splay": {
+			settings:    "interval: 60\n    align: true",
+			expectSplay: 0,
+		},
+		"when collector has schedule then have no default splay": {
+			settings:    `schedule: "*/5 * * * *"`,
+			expectSplay: 0,
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := oslog_collector.ParseConfig([]byte(`
+collectors:
+  - name: foo
+    predicate: "process == 'foo'"
+    output_file: ` + filepath.Join(t.TempDir(), "foo.log") + `
+    position_file: ` + filepath.Join(t.TempDir(), "foo.pos") + `
+    ` + tt.settings + `
+`))
+			require.NoError(t, err)
+
+			collector, err := oslog_collector.NewOSLogCollector(cfg.Collectors[0])
+			require.NoError(t, err)
+			defer collector.Close()
+
+			assert.Equal(t, tt.expectSplay, collector.Splay)
+		})
+	}
+}
+
+func TestRunLogCollector_Schedule(t *testing.T) {
+	testCases := map[string]struct {
+		now         time.Time
+		expectDelay time.Duration
+	}{
+		"when the next minute is close then run at the minute": {
+			now:         time.Date(2025, 1, 29, 10, 7, 59, 900_000_000, time.Local),
+			expectDelay: 100 * time.Millisecond,
+		},
+		"when the next minute is far then do not run at startup": {
+			now:         time.Date(2025, 1, 29, 10, 7, 30, 0, time.Local),
+			expectDelay: 30 * time.Second,
+		},
+	}
+
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			clock := newManualClock(t, tt.now)
+
+			workdir := t.TempDir()
+			cfg := oslog_collector.OSLogCollectorConfig{
+				Name:         "test",
+				Predicate:    "process == 'test'",
+				OutputFile:   filepath.Join(workdir, "test.log"),
+				PositionFile: filepath.Join(workdir, "test.pos"),
+				Schedule:     "* * * * *",
+			}
+
+			runs := make(chan time.Time, 10)
+			collector, err := oslog_collector.NewOSLogCollector(cfg,
+				oslog_collector.WithMonotonicClock(clock.Monotonic),
+				oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+					runs <- flextime.Now()
+					return testLogRunner
+				}))
+			require.NoError(t, err)
+			defer collector.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				oslog_collector.StartLogCollectors(ctx, []*oslog_collector.OSLogCollector{collector})
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				clock.Close()
+				<-done
+			}()
+
+			clock.WaitForTimers(t, 1)
+			clock.Advance(tt.expectDelay - time.Millisecond)
+			assert.Empty(t, runs, "collector runs before the schedule")
+
+			clock.Advance(time.Millisecond)
+			select {
+			case run := <-runs:
+				assert.Equal(t, time.Date(2025, 1, 29, 10, 8, 0, 0, time.Local), run)
+			case <-time.After(5 * time.Second):
+				t.Error("collector does not run at the schedule")
+			}
+
+			// the next run is a minute later
+			clock.WaitForTimers(t, 1)
+			clock.Advance(time.Minute)
+			select {
+			case run := <-runs:
+				assert.Equal(t, time.Date(2025, 1, 29, 10, 9, 0, 0, time.Local), run)
+			case <-time.After(5 * time.Second):
+				t.Error("collector does not run at the next schedule")
+			}
+		})
+	}
+}
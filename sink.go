@@ -0,0 +1,232 @@
+package oslog_collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LogEntryTimeFormat is the format of the timestamp of the log entries in the ndjson style.
+const LogEntryTimeFormat = "2006-01-02 15:04:05.000000-0700"
+
+// errUnsetOutput is returned when a collector has none of an output file, outputs and a sink.
+var errUnsetOutput = errors.New("output_file is required unless outputs or a sink are set")
+
+// LogEntry is a log entry of the log command in the ndjson style.
+type LogEntry struct {
+	// Time is Timestamp parsed in LogEntryTimeFormat, or the zero time if it cannot be parsed
+	Time time.Time `json:"-"`
+
+	Timestamp          string `json:"timestamp"`
+	EventType          string `json:"eventType"`
+	MessageType        string `json:"messageType"`
+	EventMessage       string `json:"eventMessage"`
+	FormatString       string `json:"formatString"`
+	Subsystem          string `json:"subsystem"`
+	Category           string `json:"category"`
+	ProcessID          int    `json:"processID"`
+	ThreadID           uint64 `json:"threadID"`
+	ProcessImagePath   string `json:"processImagePath"`
+	SenderImagePath    string `json:"senderImagePath"`
+	ActivityIdentifier uint64 `json:"activityIdentifier"`
+	TraceID            uint64 `json:"traceID"`
+	TimezoneName       string `json:"timezoneName"`
+
+	// Raw is the line of the entry, which has all the fields including the ones not parsed above
+	Raw json.RawMessage `json:"-"`
+}
+
+// ParseLogEntries parses the output of the log command in the ndjson style.
+func ParseLogEntries(output []byte) ([]LogEntry, error) {
+	var entries []LogEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(output)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || isLogSummaryLine(line) {
+			continue
+		}
+
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("error parsing log entry: %v", err)
+		}
+		entry.Raw = json.RawMessage(bytes.Clone(line))
+		if t, err := time.Parse(LogEntryTimeFormat, entry.Timestamp); err == nil {
+			entry.Time = t
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error parsing log entries: %v", err)
+	}
+	return entries, nil
+}
+
+// isLogSummaryLine reports whether the line is the summary such as {"count":2,"finished":1} that the log command
+// writes after the entries in the ndjson style, which is not a log entry.
+func isLogSummaryLine(line []byte) bool {
+	if !bytes.Contains(line, []byte(`"finished"`)) {
+		return false
+	}
+
+	var summary struct {
+		Finished  *int   `json:"finished"`
+		Timestamp string `json:"timestamp"`
+	}
+	return json.Unmarshal(line, &summary) == nil && summary.Finished != nil && summary.Timestamp == ""
+}
+
+// countLogEntries returns the number of log entries in the output of the log command, excluding the summary line.
+func countLogEntries(output []byte) int {
+	count := 0
+	for len(output) > 0 {
+		line := output
+		if i := bytes.IndexByte(output, '\n'); i >= 0 {
+			line, output = output[:i], output[i+1:]
+		} else {
+			output = nil
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && !isLogSummaryLine(line) {
+			count++
+		}
+	}
+	return count
+}
+
+// LogBatch is the log entries of a window collected by a collector.
+type LogBatch struct {
+	Collector string
+	Start     time.Time
+	End       time.Time
+	Entries   []LogEntry
+	// Backfill is true if the batch is collected by Backfill, which may be older than the batches already delivered
+	Backfill bool
+
+	ack     chan error
+	ackOnce sync.Once
+}
+
+// Ack acknowledges the batch received from the channel of WithSinkChannel. If err is nil, the collector commits
+// the position to the end of the window, and otherwise it collects the window again in the next cycle.
+// The collector waits for it before collecting the next window, so it must be called for each batch.
+// Only the first call takes effect, and the later ones are ignored.
+func (b *LogBatch) Ack(err error) {
+	b.ackOnce.Do(func() {
+		if b.ack != nil {
+			b.ack <- err
+		}
+	})
+}
+
+// LogSink receives the log entries of each window. The position is committed to the end of the window only if
+// the sink returns nil, and the window is collected again in the next cycle otherwise. The collector does not
+// collect the next window until the sink returns, which applies backpressure to the collector.
+type LogSink func(ctx context.Context, batch *LogBatch) error
+
+// WithSink delivers the collected log entries to the sink. It is called in addition to writing to the output
+// file, which is optional if a sink is set.
+func WithSink(sink LogSink) OSLogCollectorOption {
+	return func(c *OSLogCollector) {
+		c.pipeline.sink = sink
+	}
+}
+
+// WithSinkChannel delivers the collected log entries to the channel. The collector blocks while the channel is full,
+// and waits for the batch to be acknowledged by LogBatch.Ack before committing the position.
+func WithSinkChannel(ch chan<- *LogBatch) OSLogCollectorOption {
+	return WithSink(func(ctx context.Context, batch *LogBatch) error {
+		batch.ack = make(chan error, 1)
+
+		select {
+		case ch <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		select {
+		case err := <-batch.ack:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// pipeline delivers the log entries parsed from the output of the log command of a collector or an archive watcher
+// to the processors, the outputs and the sink.
+type pipeline struct {
+	name       string
+	sink       LogSink
+	outputs    []typedOutput
+	processors []Processor
+	// backfill marks the batches as LogBatch.Backfill
+	backfill bool
+
+	// delivered records the outputs, by index, and the sink, as sinkDestination, that received the window of
+	// deliveredKey, which are skipped when the window is delivered again after a failure until it is committed
+	deliveredKey string
+	delivered    map[int]struct{}
+}
+
+// sinkDestination is the key of the sink in pipeline.delivered
+const sinkDestination = -1
+
+// empty reports whether the pipeline has nothing to deliver the log entries to.
+func (p *pipeline) empty() bool {
+	return p.sink == nil && len(p.outputs) == 0
+}
+
+// deliver parses the output of the window, applies the processors, and delivers it to the outputs and the sink, if any.
+// The window of an archive watcher has no start and end, and the window of the first collection from --last has no start.
+// The key identifies the window, and the outputs and the sink that received it are skipped when the window of the same
+// key is delivered again after a failure, including a failure after deliver returned, until commit is called.
+func (p *pipeline) deliver(ctx context.Context, key, startTime, endTime string, output []byte) error {
+	if p.empty() {
+		return nil
+	}
+
+	entries, err := ParseLogEntries(output)
+	if err != nil {
+		return err
+	}
+
+	batch := &LogBatch{Collector: p.name, Entries: entries, Backfill: p.backfill}
+	batch.Start, _ = parseLogTimestamp(startTime)
+	batch.End, _ = parseLogTimestamp(endTime)
+
+	if err := p.process(ctx, batch); err != nil {
+		return err
+	}
+
+	if p.deliveredKey != key || p.delivered == nil {
+		p.deliveredKey = key
+		p.delivered = map[int]struct{}{}
+	}
+
+	errs := []error{p.writeOutputs(ctx, batch)}
+	if _, ok := p.delivered[sinkDestination]; !ok && p.sink != nil {
+		if err := p.sink(ctx, batch); err != nil {
+			errs = append(errs, fmt.Errorf("error delivering logs to the sink: %w", err))
+		} else {
+			p.delivered[sinkDestination] = struct{}{}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// commit forgets the outputs and the sink that received the window, after the window is written to the output file.
+func (p *pipeline) commit() {
+	p.deliveredKey = ""
+	p.delivered = nil
+}
@@ -0,0 +1,178 @@
+package oslog_collector_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// entriesOutput is the output of log entries in the ndjson style.
+const entriesOutput = `{"timestamp":"2025-01-29 00:00:30.123456+0000","eventMessage":"foo","subsystem":"com.example","processID":1}` + "\n" +
+	`{"timestamp":"2025-01-29 00:00:45.000000+0000","eventMessage":"bar","extra":true}` + "\n"
+
+func TestParseLogEntries(t *testing.T) {
+	output, err := outputRunner(entriesOutput).RunLogCommand(context.Background())
+	require.NoError(t, err)
+	// the summary line written after the entries is not an entry
+	output = append(output, []byte(`{"count":2,"finished":1}`+"\n")...)
+
+	entries, err := oslog_collector.ParseLogEntries(output)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, "foo", entries[0].EventMessage)
+	assert.Equal(t, "com.example", entries[0].Subsystem)
+	assert.Equal(t, 1, entries[0].ProcessID)
+	assert.True(t, time.Date(2025, 1, 29, 0, 0, 30, 123456000, time.UTC).Equal(entries[0].Time))
+	assert.JSONEq(t, `{"timestamp":"2025-01-29 00:00:45.000000+0000","eventMessage":"bar","extra":true}`, string(entries[1].Raw))
+
+	_, err = oslog_collector.ParseLogEntries([]byte("not json\n"))
+	assert.Error(t, err)
+}
+
+func TestOSLogCollector_Sink(t *testing.T) {
+	nowTime := time.Date(2025, 1, 29, 0, 1, 0, 0, time.UTC)
+	flextime.Fix(nowTime)
+	defer flextime.Restore()
+
+	runner := oslog_collector.WithLogCommandRunner(func(args []string) oslog_collector.LogCommandRunner {
+		return outputRunner(entriesOutput)
+	})
+
+	newConfig := func(t *testing.T) oslog_collector.OSLogCollectorConfig {
+		workdir := t.TempDir()
+		positionFile := filepath.Join(workdir, "test.pos")
+		require.NoError(t, oslog_collector.WritePosition(positionFile, oslog_collector.Position{LastTimestamp: "2025-01-29T00:00:00Z"}))
+
+		return oslog_collector.OSLogCollectorConfig{
+			Name:         "test",
+			Predicate:    "process == 'test'",
+			PositionFile: positionFile,
+			Interval:     60,
+		}
+	}
+
+	t.Run("when the sink succeeds then the position is committed", func(t *testing.T) {
+		var batches []*oslog_collector.LogBatch
+		collector, err := oslog_collector.NewOSLogCollector(newConfig(t), runner, oslog_collector.WithSink(func(ctx context.Context, batch *oslog_collector.LogBatch) error {
+			batches = append(batches, batch)
+			return nil
+		}))
+		require.NoError(t, err)
+		defer collector.Close()
+
+		require.NoError(t, collector.CollectLogs(context.Background()))
+
+		require.Len(t, batches, 1)
+		assert.Equal(t, "test", batches[0].Collector)
+		assert.True(t, time.Date(2025, 1, 29, 0, 0, 0, 0, time.UTC).Equal(batches[0].Start))
+		assert.True(t, nowTime.Equal(batches[0].End))
+		assert.Len(t, batches[0].Entries, 2)
+		assert.Equal(t, "2025-01-29T00:01:00Z", collector.LastTimestamp)
+	})
+
+	t.Run("when the sink fails then the position is not committed", func(t *testing.T) {
+		collector, err := oslog_collector.NewOSLogCollector(newConfig(t), runner, oslog_collector.WithSink(func(ctx context.Context, batch *oslog_collector.LogBatch) error {
+			return errors.New("sink error")
+		}))
+		require.NoError(t, err)
+		defer collector.Close()
+
+		assert.ErrorContains(t, collector.CollectLogs(context.Background()), "error delivering logs to the sink: sink error")
+		assert.Equal(t, "2025-01-29T00:00:00Z", collector.LastTimestamp)
+	})
+
+	t.Run("when the sink fails then the output file is not written", func(t *testing.T) {
+		cfg := newConfig(t)
+		cfg.OutputFile = filepath.Join(t.TempDir(), "test.log")
+
+		fail := true
+		collector, err := oslog_collector.NewOSLogCollector(cfg, runner, oslog_collector.WithSink(func(ctx context.Context, batch *oslog_collector.LogBatch) error {
+			if fail {
+				return errors.New("sink error")
+			}
+			return nil
+		}))
+		require.NoError(t, err)
+		defer collector.Close()
+
+		require.Error(t, collector.CollectLogs(context.Background()))
+		fail = false
+		require.NoError(t, collector.CollectLogs(context.Background()))
+
+		output, err := os.ReadFile(cfg.OutputFile)
+		require.NoError(t, err)
+		assert.Equal(t, 2, strings.Count(string(output), "\n"), "the window is written once")
+	})
+
+	t.Run("when a batch of the channel is acked then the position is committed only on success", func(t *testing.T) {
+		ch := make(chan *oslog_collector.LogBatch)
+		collector, err := oslog_collector.NewOSLogCollector(newConfig(t), runner, oslog_collector.WithSinkChannel(ch))
+		require.NoError(t, err)
+		defer collector.Close()
+
+		tests := []struct {
+			ack           error
+			lastTimestamp string
+		}{
+			{ack: errors.New("nack"), lastTimestamp: "2025-01-29T00:00:00Z"},
+			{ack: nil, lastTimestamp: "2025-01-29T00:01:00Z"},
+		}
+		for _, tt := range tests {
+			errCh := make(chan error, 1)
+			go func() { errCh <- collector.CollectLogs(context.Background()) }()
+
+			batch := <-ch
+			assert.Len(t, batch.Entries, 2)
+			batch.Ack(tt.ack)
+			// later calls are ignored instead of blocking
+			batch.Ack(errors.New("ignored"))
+
+			if tt.ack != nil {
+				assert.ErrorIs(t, <-errCh, tt.ack)
+			} else {
+				assert.NoError(t, <-errCh)
+			}
+			assert.Equal(t, tt.lastTimestamp, collector.LastTimestamp)
+		}
+	})
+
+	t.Run("when the context is canceled while the channel is full then the position is not committed", func(t *testing.T) {
+		ch := make(chan *oslog_collector.LogBatch)
+		collector, err := oslog_collector.NewOSLogCollector(newConfig(t), runner, oslog_collector.WithSinkChannel(ch))
+		require.NoError(t, err)
+		defer collector.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, collector.CollectLogs(ctx), context.DeadlineExceeded)
+		assert.Equal(t, "2025-01-29T00:00:00Z", collector.LastTimestamp)
+	})
+
+	t.Run("when none of an output file, outputs and a sink is set then it returns an error", func(t *testing.T) {
+		_, err := oslog_collector.NewOSLogCollector(newConfig(t), runner)
+		assert.ErrorContains(t, err, "output_file is required unless outputs or a sink are set")
+	})
+
+	t.Run("when the position file is not set then it returns an error", func(t *testing.T) {
+		config := newConfig(t)
+		config.PositionFile = ""
+		_, err := oslog_collector.NewOSLogCollector(config, runner, oslog_collector.WithSink(func(ctx context.Context, batch *oslog_collector.LogBatch) error {
+			return nil
+		}))
+		assert.EqualError(t, err, "position_file is required")
+
+		_, err = os.Stat(".lock")
+		assert.True(t, os.IsNotExist(err), "the lock file is not created in the working directory")
+	})
+}
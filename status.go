@@ -0,0 +1,227 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+const defaultReadyIntervals = 3
+
+// AgentStatus is the status of the agent served on /status and by the status control command.
+type AgentStatus struct {
+	Collectors      []CollectorStatus      `json:"collectors"`
+	ArchiveWatchers []ArchiveWatcherStatus `json:"archive_watchers"`
+}
+
+// CollectorStatus is the status of a collector served on /status.
+type CollectorStatus struct {
+	Name         string `json:"name"`
+	Predicate    string `json:"predicate"`
+	OutputFile   string `json:"output_file"`
+	PositionFile string `json:"position_file"`
+	Interval     int    `json:"interval"`
+	Schedule     string `json:"schedule,omitempty"`
+	// CurrentInterval is the current seconds of the adaptive interval, which is omitted if the interval is not adaptive
+	CurrentInterval int    `json:"current_interval,omitempty"`
+	LastTimestamp   string `json:"last_timestamp"`
+	// LagSeconds is the seconds between now and LastTimestamp, which is omitted before the first collection from --last
+	LagSeconds  *float64   `json:"lag_seconds,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Ready       bool       `json:"ready"`
+	Paused      bool       `json:"paused"`
+	// Degraded is true while the circuit breaker is open and the collector runs at the probe interval
+	Degraded            bool `json:"degraded"`
+	ConsecutiveFailures int  `json:"consecutive_failures"`
+}
+
+// ArchiveWatcherStatus is the status of an archive watcher served on /status.
+type ArchiveWatcherStatus struct {
+	Name       string `json:"name"`
+	Directory  string `json:"directory"`
+	OutputFile string `json:"output_file"`
+	StateFile  string `json:"state_file"`
+	Interval   int    `json:"interval"`
+	// PendingArchives are the bundles waiting to become stable
+	PendingArchives []string   `json:"pending_archives"`
+	LastSuccess     *time.Time `json:"last_success,omitempty"`
+	LastFailure     *time.Time `json:"last_failure,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	Ready           bool       `json:"ready"`
+}
+
+// collectorResult is the result of the last collections of a collector, or the last scans of an archive watcher.
+type collectorResult struct {
+	// firstDue is when the first collection is due, from which the readiness is measured until the first success
+	firstDue    time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+// record records the result of a collection or a scan.
+func (r *collectorResult) record(err error) {
+	if err != nil {
+		r.lastFailure = flextime.Now()
+		r.lastError = err.Error()
+		return
+	}
+	r.lastSuccess = flextime.Now()
+}
+
+// ready returns whether the last success is within the period. Before the first run, the period is measured from
+// when the first run is due, and the result is not ready after the first runs failed.
+func (r *collectorResult) ready(now time.Time, period time.Duration) bool {
+	since := r.lastSuccess
+	if since.IsZero() {
+		if !r.lastFailure.IsZero() {
+			return false
+		}
+		since = r.firstDue
+	}
+	return now.Sub(since) <= period
+}
+
+// setTimes sets LastSuccess and LastFailure of the status if they are set.
+func (r *collectorResult) setTimes(lastSuccess, lastFailure **time.Time) {
+	if !r.lastSuccess.IsZero() {
+		t := r.lastSuccess
+		*lastSuccess = &t
+	}
+	if !r.lastFailure.IsZero() {
+		t := r.lastFailure
+		*lastFailure = &t
+	}
+}
+
+// recordResult records the result of a collection for the status.
+func (c *OSLogCollector) recordResult(err error) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.result.record(err)
+}
+
+// setFirstDue sets when the first collection is due after the collector starts.
+func (c *OSLogCollector) setFirstDue(t time.Time) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.result.firstDue = t
+}
+
+// setLastTimestamp updates LastTimestamp, which is read by Status from other goroutines.
+func (c *OSLogCollector) setLastTimestamp(lastTimestamp string) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.LastTimestamp = lastTimestamp
+}
+
+// Status returns the status of the collector. The collector is ready if it has succeeded within readyIntervals intervals,
+// or within readyIntervals periods of the schedule. Before the first collection, they are measured from when it is due,
+// so that a collector that is not due yet after a restart or a reload is ready.
+func (c *OSLogCollector) Status(readyIntervals int) CollectorStatus {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	now := flextime.Now()
+	status := CollectorStatus{
+		Name:                c.Name,
+		Predicate:           c.Predicate,
+		OutputFile:          c.OutputFile,
+		PositionFile:        c.PositionFile,
+		Interval:            c.Interval,
+		Schedule:            c.Schedule,
+		LastTimestamp:       c.LastTimestamp,
+		LastError:           c.result.lastError,
+		Paused:              c.paused,
+		Degraded:            c.degraded,
+		ConsecutiveFailures: c.consecutiveFailures,
+	}
+
+	if c.adaptive() {
+		status.CurrentInterval = int(c.currentInterval / time.Second)
+	}
+
+	if t, err := parseLogTimestamp(c.LastTimestamp); err == nil {
+		lag := now.Sub(t).Seconds()
+		status.LagSeconds = &lag
+	}
+
+	c.result.setTimes(&status.LastSuccess, &status.LastFailure)
+	status.Ready = c.result.ready(now, time.Duration(readyIntervals)*c.period(now))
+
+	return status
+}
+
+// notReadyReason explains why the collector is not ready.
+func (s CollectorStatus) notReadyReason(readyIntervals int) string {
+	if s.LastSuccess == nil && s.LastFailure == nil {
+		return fmt.Sprintf("collector %s has not run in the first %d intervals", s.Name, readyIntervals)
+	}
+	if s.LastSuccess == nil {
+		return fmt.Sprintf("collector %s has not succeeded yet", s.Name)
+	}
+	return fmt.Sprintf("collector %s has not succeeded in the last %d intervals", s.Name, readyIntervals)
+}
+
+// recordResult records the result of a scan for the status.
+func (w *ArchiveWatcher) recordResult(err error) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	w.result.record(err)
+}
+
+// Status returns the status of the archive watcher. The archive watcher is ready if it has scanned the directory
+// within readyIntervals intervals, which are measured from when it started before the first scan.
+func (w *ArchiveWatcher) Status(readyIntervals int) ArchiveWatcherStatus {
+	pending := w.PendingArchives()
+
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	status := ArchiveWatcherStatus{
+		Name:            w.Name,
+		Directory:       w.Directory,
+		OutputFile:      w.OutputFile,
+		StateFile:       w.StateFile,
+		Interval:        w.Interval,
+		PendingArchives: pending,
+		LastError:       w.result.lastError,
+	}
+
+	w.result.setTimes(&status.LastSuccess, &status.LastFailure)
+	status.Ready = w.result.ready(flextime.Now(), time.Duration(readyIntervals*w.Interval)*time.Second)
+
+	return status
+}
+
+// notReadyReason explains why the archive watcher is not ready.
+func (s ArchiveWatcherStatus) notReadyReason(readyIntervals int) string {
+	if s.LastSuccess == nil && s.LastFailure == nil {
+		return fmt.Sprintf("archive watcher %s has not scanned its directory in the first %d intervals", s.Name, readyIntervals)
+	}
+	if s.LastSuccess == nil {
+		return fmt.Sprintf("archive watcher %s has not scanned its directory yet", s.Name)
+	}
+	return fmt.Sprintf("archive watcher %s has not scanned its directory in the last %d intervals", s.Name, readyIntervals)
+}
+
+// Status returns the status of the running collectors and archive watchers.
+func (a *Agent) Status() AgentStatus {
+	readyIntervals := a.readyIntervals()
+
+	status := AgentStatus{Collectors: []CollectorStatus{}, ArchiveWatchers: []ArchiveWatcherStatus{}}
+	for _, collector := range a.Collectors() {
+		status.Collectors = append(status.Collectors, collector.Status(readyIntervals))
+	}
+	for _, watcher := range a.Watchers() {
+		status.ArchiveWatchers = append(status.ArchiveWatchers, watcher.Status(readyIntervals))
+	}
+	return status
+}
@@ -0,0 +1,85 @@
+package oslog_collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts is a list of the layouts of absolute times accepted by ParseTime, which are interpreted in the local timezone
+// unless they have an offset.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime parses an absolute time such as "2025-01-29 12:00:00" or RFC 3339,
+// or a time relative to now such as "now", "-6h", "-30m" or "-2d".
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "now" {
+		return now, nil
+	}
+
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		d, err := ParseDuration(s[1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time: %q", s)
+		}
+		if s[0] == '-' {
+			d = -d
+		}
+		return now.Add(d), nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time: %q, must be like \"2006-01-02 15:04:05\", RFC 3339, \"now\" or \"-6h\"", s)
+}
+
+// ParseDuration parses a duration such as "90s", "6h" or "2d", which is time.ParseDuration with the d (day) unit.
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %q", s)
+	}
+	return d, nil
+}
+
+// legacyPositionTimeFormat is the format of the positions saved by older versions, which has no offset from UTC.
+const legacyPositionTimeFormat = "2006-01-02 15:04:05"
+
+// parseLogTimestamp parses a time formatted in PositionTimeFormat, such as a position.
+// A time saved in legacyPositionTimeFormat is interpreted in the local timezone.
+func parseLogTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(PositionTimeFormat, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(legacyPositionTimeFormat, s, time.Local)
+}
+
+// logCommandTime converts a time formatted in PositionTimeFormat to LogCommandTimeFormat.
+// It returns s as is if it cannot be parsed, which is rejected by the log command.
+func logCommandTime(s string) string {
+	t, err := parseLogTimestamp(s)
+	if err != nil {
+		return s
+	}
+	return t.Format(LogCommandTimeFormat)
+}
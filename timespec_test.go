@@ -0,0 +1,80 @@
+package oslog_collector_test
+
+import (
+	"testing"
+	"time"
+
+	oslog_collector "github.com/mrtc0/oslog-collector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTime(t *testing.T) {
+	t.Parallel()
+
+	loc := time.FixedZone("JST", 9*60*60)
+	now := time.Date(2025, 1, 29, 12, 0, 0, 0, loc)
+
+	testCases := map[string]struct {
+		input     string
+		expect    time.Time
+		expectErr bool
+	}{
+		"now": {
+			input:  "now",
+			expect: now,
+		},
+		"relative hours": {
+			input:  "-6h",
+			expect: now.Add(-6 * time.Hour),
+		},
+		"relative minutes and seconds": {
+			input:  "-1h30m",
+			expect: now.Add(-90 * time.Minute),
+		},
+		"relative days": {
+			input:  "-2d",
+			expect: now.Add(-48 * time.Hour),
+		},
+		"relative future": {
+			input:  "+10m",
+			expect: now.Add(10 * time.Minute),
+		},
+		"log command format": {
+			input:  "2025-01-28 23:59:59",
+			expect: time.Date(2025, 1, 28, 23, 59, 59, 0, loc),
+		},
+		"date only": {
+			input:  "2025-01-28",
+			expect: time.Date(2025, 1, 28, 0, 0, 0, 0, loc),
+		},
+		"RFC 3339": {
+			input:  "2025-01-28T23:59:59Z",
+			expect: time.Date(2025, 1, 28, 23, 59, 59, 0, time.UTC),
+		},
+		"invalid relative time": {
+			input:     "-6x",
+			expectErr: true,
+		},
+		"invalid time": {
+			input:     "yesterday",
+			expectErr: true,
+		},
+	}
+
+	for name, tt := range testCases {
+		tt := tt
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := oslog_collector.ParseTime(tt.input, now)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.True(t, tt.expect.Equal(actual), "expected %s, got %s", tt.expect, actual)
+		})
+	}
+}